@@ -0,0 +1,571 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/samber/lo"
+	"github.com/xanzy/go-gitlab"
+	"gorm.io/gorm"
+	"log"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/events"
+	"mfe-worker/internal/fsDriver"
+	"mfe-worker/internal/queue"
+	"mfe-worker/internal/shell"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrRevisionExists = errors.New("revision already exists")
+var ErrRefNameTaken = errors.New("branch and tag can't have the same name, they share storage path")
+var ErrBuildInProgress = errors.New("build of revision is already queued or running")
+var ErrNothingToCancel = errors.New("build of revision is not queued or running")
+var ErrJobNotQueued = errors.New("job is not waiting in queue")
+
+const (
+	StepPrepare = "prepare"
+	StepClone   = "clone"
+	StepCollect = "collect files"
+)
+
+// StepError describes which step of build pipeline was failed
+type StepError struct {
+	Step     string
+	ExitCode int
+	Err      error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("build step `%s` failed: %s", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+func stepError(step string, err error) *StepError {
+	return &StepError{Step: step, Err: err}
+}
+
+func commandError(step string, err error) *StepError {
+	return &StepError{Step: step, ExitCode: shell.ExitCode(err), Err: err}
+}
+
+type Builder struct {
+	queue        *queue.Queue
+	fsDriver     *fsDriver.FSDriver
+	dbDriver     *dbDriver.DBDriver
+	configMap    *configMap.ConfigMap
+	gitlabClient *gitlab.Client
+	events       *events.Bus
+	logs         *logHub
+	mirrorMu     sync.Mutex
+	mirrorLocks  map[string]chan struct{}
+	masksMu      sync.RWMutex
+	masks        map[uint]*strings.Replacer
+}
+
+// RequestBuild builds head commit of branch, queued builds of older commits of branch are superseded
+func (b *Builder) RequestBuild(project *configMap.Project, branchName string, commitId string) (*dbDriver.Job, error) {
+	return b.requestBuild(project, branchName, false, commitId, true)
+}
+
+// RequestCommitBuild builds pinned commit of branch, e.g. for rollback, so it doesn't supersede other builds of branch
+func (b *Builder) RequestCommitBuild(project *configMap.Project, branchName string, commitId string) (*dbDriver.Job, error) {
+	return b.requestBuild(project, branchName, false, commitId, false)
+}
+
+func (b *Builder) RequestTagBuild(project *configMap.Project, tagName string, commitId string) (*dbDriver.Job, error) {
+	return b.requestBuild(project, tagName, true, commitId, true)
+}
+
+func (b *Builder) requestBuild(project *configMap.Project, branchName string, isTag bool, commitId string, isHead bool) (*dbDriver.Job, error) {
+	branch, err := b.dbDriver.GetRef(project.ProjectID, branchName, isTag)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if branch == nil {
+		// files of branch and tag are stored by name, so new ref can't take name of the other kind
+		_, err = b.dbDriver.GetRef(project.ProjectID, branchName, !isTag)
+		if err == nil {
+			return nil, ErrRefNameTaken
+		}
+
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		branch, err = b.dbDriver.CreateBranch(&dbDriver.Branch{
+			Name:      branchName,
+			Tag:       isTag,
+			ProjectId: project.ProjectID,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// branch was removed in gitlab earlier and now it is pushed again
+	if branch.RemovedAt != nil {
+		if err := b.dbDriver.SetBranchRemovedAt(branch, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, revision := range branch.Revisions {
+		if revision.Name == commitId {
+			return nil, ErrRevisionExists
+		}
+	}
+
+	revision, err := b.dbDriver.CreateRevision(&dbDriver.Revision{
+		Name:     commitId,
+		BranchId: branch.ID,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = b.dbDriver.CreateBuild(&dbDriver.Build{
+		Status:     dbDriver.BuildStatusQueued,
+		RevisionId: revision.ID,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isHead {
+		return b.enqueue(project, branchName, isTag, revision)
+	}
+
+	return b.enqueueLatest(project, branchName, isTag, revision)
+}
+
+func (b *Builder) RebuildRevision(project *configMap.Project, branchName string, revisionName string) (*dbDriver.Job, error) {
+	return b.rebuildRevision(project, branchName, false, revisionName)
+}
+
+func (b *Builder) RebuildTagRevision(project *configMap.Project, tagName string, revisionName string) (*dbDriver.Job, error) {
+	return b.rebuildRevision(project, tagName, true, revisionName)
+}
+
+func (b *Builder) rebuildRevision(project *configMap.Project, branchName string, isTag bool, revisionName string) (*dbDriver.Job, error) {
+	revision, err := b.dbDriver.FindRefRevision(project.ProjectID, branchName, isTag, revisionName)
+	if err != nil {
+		return nil, err
+	}
+
+	// files of previous build are kept, rebuild is published to new dir, see fsDriver.RevisionDirName
+	_, err = b.dbDriver.QueueRebuild(revision.ID)
+	if errors.Is(err, dbDriver.ErrBuildActive) {
+		return nil, ErrBuildInProgress
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b.enqueue(project, branchName, isTag, revision)
+}
+
+// CancelRevision aborts queued or running build of revision, build is marked as cancelled
+func (b *Builder) CancelRevision(project *configMap.Project, branchName string, revisionName string) error {
+	return b.cancelRevision(project, branchName, false, revisionName)
+}
+
+func (b *Builder) CancelTagRevision(project *configMap.Project, tagName string, revisionName string) error {
+	return b.cancelRevision(project, tagName, true, revisionName)
+}
+
+func (b *Builder) cancelRevision(project *configMap.Project, branchName string, isTag bool, revisionName string) error {
+	revision, err := b.dbDriver.FindRefRevision(project.ProjectID, branchName, isTag, revisionName)
+	if err != nil {
+		return err
+	}
+
+	build, err := b.dbDriver.GetBuildByRevision(revision.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNothingToCancel
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if build.Status != dbDriver.BuildStatusQueued && build.Status != dbDriver.BuildStatusInProgress {
+		return ErrNothingToCancel
+	}
+
+	// running job marks its build by itself when context is cancelled
+	if _, running := b.queue.Cancel(revision.ID); running {
+		return nil
+	}
+
+	build.Status = dbDriver.BuildStatusCancelled
+	_, err = b.dbDriver.UpdateBuild(build)
+	return err
+}
+
+// DropJob removes waiting job from queue, build of its revision is marked as cancelled
+func (b *Builder) DropJob(jobId uint) error {
+	job := b.queue.Remove(jobId)
+	if job == nil {
+		if _, err := b.dbDriver.GetJob(jobId); err != nil {
+			return err
+		}
+
+		return ErrJobNotQueued
+	}
+
+	build, err := b.dbDriver.GetBuildByRevision(job.RevisionId)
+	if err != nil {
+		return err
+	}
+
+	build.Status = dbDriver.BuildStatusCancelled
+	_, err = b.dbDriver.UpdateBuild(build)
+	return err
+}
+
+// enqueue queues pinned job of revision, pushes to branch don't supersede it
+func (b *Builder) enqueue(project *configMap.Project, branchName string, isTag bool, revision *dbDriver.Revision) (*dbDriver.Job, error) {
+	job := newJob(project, branchName, isTag, revision, true)
+	return job, b.queue.AddToQueue(job)
+}
+
+// enqueueLatest queues revision as the newest commit of branch, queued head builds of older commits are superseded
+func (b *Builder) enqueueLatest(project *configMap.Project, branchName string, isTag bool, revision *dbDriver.Revision) (*dbDriver.Job, error) {
+	job := newJob(project, branchName, isTag, revision, false)
+
+	superseded, err := b.queue.Supersede(job, project.CancelSuperseded)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range superseded {
+		build, err := b.dbDriver.GetBuildByRevision(job.RevisionId)
+		if err != nil {
+			log.Printf("failed on get superseded build of revision %s: %s", job.Commit, err)
+			continue
+		}
+
+		build.Status = dbDriver.BuildStatusSuperseded
+		if _, err := b.dbDriver.UpdateBuild(build); err != nil {
+			log.Printf("failed on save superseded build state: %s", err)
+		}
+	}
+
+	return job, nil
+}
+
+// newJob creates job of revision, pinned job builds exactly its revision and is not superseded by pushes
+func newJob(project *configMap.Project, branchName string, isTag bool, revision *dbDriver.Revision, pinned bool) *dbDriver.Job {
+	return &dbDriver.Job{
+		ProjectId:  project.ProjectID,
+		Branch:     branchName,
+		Commit:     revision.Name,
+		RevisionId: revision.ID,
+		Tag:        isTag,
+		Pinned:     pinned,
+	}
+}
+
+func (b *Builder) RunJob(ctx context.Context, job *dbDriver.Job) error {
+	project := b.configMap.FindProject(job.ProjectId)
+	if project == nil {
+		return b.failJobBuild(job, fmt.Errorf("project `%s` of job #%d not found in config", job.ProjectId, job.ID))
+	}
+
+	revision, err := b.dbDriver.GetRevision(job.RevisionId)
+	if err != nil {
+		return b.failJobBuild(job, errors.Join(fmt.Errorf("failed on get revision of job #%d", job.ID), err))
+	}
+
+	build, err := b.prepareBuild(revision)
+	if err != nil {
+		return b.failJobBuild(job, err)
+	}
+
+	b.logs.openBuild(build.ID)
+	defer b.logs.closeBuild(build.ID)
+
+	secrets, err := project.ResolveSecrets()
+	if err != nil {
+		err = stepError(StepPrepare, err)
+		b.failBuild(build, err)
+		return err
+	}
+
+	b.setMasks(build.ID, secrets)
+	defer b.clearMasks(build.ID)
+
+	if project.BuildTimeout.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, project.BuildTimeout.Duration)
+		defer cancel()
+	}
+
+	if err = b.maskError(build.ID, b.build(ctx, project, job, revision, build, buildEnv(project, secrets))); err != nil {
+		if cause := context.Cause(ctx); errors.Is(cause, queue.ErrCancelled) || errors.Is(cause, queue.ErrSuperseded) {
+			b.cancelBuild(build, cause)
+			return err
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = errors.Join(fmt.Errorf("build timeout of %s exceeded", project.BuildTimeout.Duration), err)
+		}
+
+		b.failBuild(build, err)
+		return err
+	}
+
+	b.publish(events.TypeLatestChanged, job, "")
+	return nil
+}
+
+func (b *Builder) build(ctx context.Context, project *configMap.Project, job *dbDriver.Job, revision *dbDriver.Revision, build *dbDriver.Build, env []string) error {
+	branchName := job.Branch
+
+	b.publish(events.TypeBuildStep, job, StepPrepare)
+
+	gitProject, _, err := b.gitlabClient.Projects.GetProject(
+		project.ProjectID,
+		&gitlab.GetProjectOptions{},
+		gitlab.WithContext(ctx),
+	)
+
+	if err != nil {
+		return stepError(StepPrepare, err)
+	}
+
+	defer func(fsDriver *fsDriver.FSDriver, projectId string, branch string, revision string) {
+		err := fsDriver.RemoveTmpDirForBuild(projectId, branch, revision)
+		if err != nil {
+			log.Printf("failed on clear tmp dir: %s", err)
+		}
+	}(b.fsDriver, project.ProjectID, branchName, revision.Name)
+
+	tmpDirName, err := filepath.Abs(b.fsDriver.GetTmpPathForBuild(project.ProjectID, branchName, revision.Name))
+	if err != nil {
+		return stepError(StepPrepare, err)
+	}
+
+	executor, err := b.executor(project, tmpDirName)
+	if err != nil {
+		return stepError(StepPrepare, err)
+	}
+
+	// tmp dir could be left by interrupted run of the same job
+	if b.fsDriver.HasTmpDirForBuild(project.ProjectID, branchName, revision.Name) {
+		log.Printf("tmp dir already exists, clear it before build: %s", tmpDirName)
+		if err := b.fsDriver.RemoveTmpDirForBuild(project.ProjectID, branchName, revision.Name); err != nil {
+			return stepError(StepPrepare, err)
+		}
+	}
+
+	remote, err := b.gitRemote(project, gitProject)
+	if err != nil {
+		return stepError(StepPrepare, err)
+	}
+
+	b.publish(events.TypeBuildStep, job, StepClone)
+
+	if err := b.cloneFromMirror(ctx, project, build, remote, tmpDirName); err != nil {
+		return commandError(StepClone, err)
+	}
+
+	// ref could be moved since revision was requested, so build exactly requested commit
+	checkoutArgs := []string{"checkout", "--detach", revision.Name}
+
+	checkoutExecArgs := shell.ExecShellCommandArgs{Cwd: tmpDirName, OnLine: b.logLines(build, "git checkout --detach "+revision.Name)}
+
+	if _, err = shell.ExecShellCommand(ctx, "git", checkoutArgs, checkoutExecArgs); err != nil {
+		return commandError(StepClone, errors.Join(fmt.Errorf("failed on checkout revision: %s", revision.Name), err))
+	}
+
+	cacheKey := ""
+	if len(project.Cache.Paths) > 0 {
+		cacheKey = b.restoreCache(project, build, tmpDirName)
+	}
+
+	for _, cmd := range project.BuildCommands {
+		cmdName, cmdArgs, cmdEnv, err := cmd.Argv()
+		if err != nil {
+			return stepError(cmd.String(), err)
+		}
+
+		b.publish(events.TypeBuildStep, job, cmd.String())
+
+		cmdExecArgs := shell.ExecShellCommandArgs{
+			Cwd:      filepath.Join(tmpDirName, cmd.Cwd),
+			Debug:    true,
+			OnLine:   b.logLines(build, cmd.String()),
+			Timeout:  project.CommandTimeout.Duration,
+			Env:      append(append([]string{}, env...), cmdEnv...),
+			CleanEnv: true,
+			Mask:     b.masker(build.ID),
+		}
+
+		if _, err = executor.Exec(ctx, cmdName, cmdArgs, cmdExecArgs); err != nil {
+			return commandError(cmd.String(), errors.Join(fmt.Errorf("failed on exec build command from cfg: %s ", cmd), err))
+		}
+	}
+
+	if len(cacheKey) > 0 && ctx.Err() == nil {
+		b.saveCache(project, build, cacheKey, tmpDirName)
+	}
+
+	b.publish(events.TypeBuildStep, job, StepCollect)
+
+	projectExists := b.fsDriver.HasProjectDir(project.ProjectID)
+	if !projectExists {
+		if err := b.fsDriver.CreateProjectDir(project.ProjectID); err != nil {
+			return stepError(StepCollect, err)
+		}
+	}
+
+	branchExists := b.fsDriver.HasProjectBranchDir(project.ProjectID, branchName)
+	if !branchExists {
+		if err := b.fsDriver.CreateProjectBranchDir(project.ProjectID, branchName); err != nil {
+			return stepError(StepCollect, err)
+		}
+	}
+
+	revisionDir := fsDriver.RevisionDirName(revision.Name, build.Rebuilds)
+	branchRevisionExists := b.fsDriver.HasBranchRevisionDir(project.ProjectID, branchName, revisionDir)
+	if !branchRevisionExists {
+		if err := b.fsDriver.CreateBranchRevisionDir(project.ProjectID, branchName, revisionDir); err != nil {
+			return stepError(StepCollect, err)
+		}
+	}
+
+	// cancel request could come after last command, don't publish files of cancelled build
+	if err := ctx.Err(); err != nil {
+		return stepError(StepCollect, err)
+	}
+
+	pickedFiles, err := b.fsDriver.PickFilesToWebStorage(project, branchName, revisionDir, tmpDirName)
+	if err != nil {
+		return stepError(StepCollect, err)
+	}
+
+	var buildFiles []dbDriver.BuildFiles
+	for _, file := range pickedFiles {
+		buildFiles = append(buildFiles, dbDriver.BuildFiles{
+			Path:      file.Path,
+			WebPath:   file.WebPath,
+			Sha256:    file.Sha256,
+			Integrity: file.Integrity,
+			BuildId:   build.ID,
+		})
+	}
+
+	b.logs.flush(build.ID)
+
+	build.Files = buildFiles
+	build.Status = dbDriver.BuildStatusReady
+	_, err = b.dbDriver.UpdateBuild(build)
+	return err
+}
+
+func (b *Builder) failBuild(build *dbDriver.Build, err error) {
+	b.logs.flush(build.ID)
+
+	build.Status = dbDriver.BuildStatusFailed
+	build.ErrorMessage = err.Error()
+	build.ExitCode = 0
+	build.FailedStep = ""
+
+	var stepErr *StepError
+	if errors.As(err, &stepErr) {
+		build.FailedStep = stepErr.Step
+		build.ExitCode = stepErr.ExitCode
+	}
+
+	if _, err := b.dbDriver.UpdateBuild(build); err != nil {
+		log.Printf("failed on save failed build state: %s", err)
+	}
+}
+
+// failJobBuild marks build of job which couldn't be started as failed on prepare step, so it isn't left queued
+func (b *Builder) failJobBuild(job *dbDriver.Job, err error) error {
+	err = stepError(StepPrepare, err)
+
+	build, getErr := b.dbDriver.GetBuildByRevision(job.RevisionId)
+	if getErr != nil {
+		log.Printf("failed on get build of job #%d: %s", job.ID, getErr)
+		return err
+	}
+
+	b.failBuild(build, err)
+	return err
+}
+
+func (b *Builder) cancelBuild(build *dbDriver.Build, cause error) {
+	b.logs.flush(build.ID)
+
+	build.Status = lo.Ternary[dbDriver.BuildStatus](errors.Is(cause, queue.ErrSuperseded), dbDriver.BuildStatusSuperseded, dbDriver.BuildStatusCancelled)
+	build.ErrorMessage = cause.Error()
+
+	if _, err := b.dbDriver.UpdateBuild(build); err != nil {
+		log.Printf("failed on save cancelled build state: %s", err)
+	}
+}
+
+// prepareBuild creates build of revision or resets build which was left by interrupted run
+func (b *Builder) prepareBuild(revision *dbDriver.Revision) (*dbDriver.Build, error) {
+	build, err := b.dbDriver.GetBuildByRevision(revision.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return b.dbDriver.CreateBuild(&dbDriver.Build{
+			Status:     dbDriver.BuildStatusInProgress,
+			RevisionId: revision.ID,
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b.resetBuild(build, dbDriver.BuildStatusInProgress)
+}
+
+// resetBuild drops results of previous run of build and saves it with given status
+func (b *Builder) resetBuild(build *dbDriver.Build, status dbDriver.BuildStatus) (*dbDriver.Build, error) {
+	if build.ID != 0 {
+		if err := b.dbDriver.DeleteBuildFiles(build); err != nil {
+			return nil, err
+		}
+
+		if err := b.dbDriver.DeleteBuildLogs(build); err != nil {
+			return nil, err
+		}
+	}
+
+	build.Files = nil
+	build.Status = status
+	build.FailedStep = ""
+	build.ExitCode = 0
+	build.ErrorMessage = ""
+	return b.dbDriver.UpdateBuild(build)
+}
+
+func NewBuilder(configMap *configMap.ConfigMap, queue *queue.Queue, fsDriver *fsDriver.FSDriver, dbDriver *dbDriver.DBDriver, gitlabClient *gitlab.Client, events *events.Bus) *Builder {
+	return &Builder{
+		queue:        queue,
+		fsDriver:     fsDriver,
+		dbDriver:     dbDriver,
+		configMap:    configMap,
+		gitlabClient: gitlabClient,
+		events:       events,
+		logs:         newLogHub(dbDriver),
+		mirrorLocks:  map[string]chan struct{}{},
+		masks:        map[uint]*strings.Replacer{},
+	}
+}
@@ -0,0 +1,52 @@
+package builder
+
+import (
+	"fmt"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/shell"
+)
+
+const cacheLogCommand = "dependency cache"
+
+// restoreCache puts cached dependencies into repo before build commands and returns cache key,
+// cache is optimization only, so its errors are written to build log and build continues
+func (b *Builder) restoreCache(project *configMap.Project, build *dbDriver.Build, repoPath string) string {
+	logLine := b.logLines(build, cacheLogCommand)
+
+	key, err := b.fsDriver.CacheKey(project, repoPath)
+	if err != nil {
+		logLine(shell.StreamStderr, fmt.Sprintf("failed on compute cache key: %s", err))
+		return ""
+	}
+
+	if len(key) == 0 {
+		logLine(shell.StreamStdout, "no lockfiles found, cache is skipped")
+		return ""
+	}
+
+	restored, err := b.fsDriver.RestoreCache(project, key, repoPath)
+	if err != nil {
+		logLine(shell.StreamStderr, fmt.Sprintf("failed on restore cache %s: %s", key, err))
+		return key
+	}
+
+	if restored {
+		logLine(shell.StreamStdout, fmt.Sprintf("cache %s restored", key))
+	} else {
+		logLine(shell.StreamStdout, fmt.Sprintf("cache %s not found", key))
+	}
+
+	return key
+}
+
+func (b *Builder) saveCache(project *configMap.Project, build *dbDriver.Build, key string, repoPath string) {
+	logLine := b.logLines(build, cacheLogCommand)
+
+	if err := b.fsDriver.SaveCache(project, key, repoPath); err != nil {
+		logLine(shell.StreamStderr, fmt.Sprintf("failed on save cache %s: %s", key, err))
+		return
+	}
+
+	logLine(shell.StreamStdout, fmt.Sprintf("cache %s saved", key))
+}
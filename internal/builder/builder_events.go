@@ -0,0 +1,18 @@
+package builder
+
+import (
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/events"
+)
+
+func (b *Builder) publish(eventType string, job *dbDriver.Job, step string) {
+	b.events.Publish(events.Event{
+		Type:      eventType,
+		ProjectId: job.ProjectId,
+		Branch:    job.Branch,
+		Tag:       job.Tag,
+		Revision:  job.Commit,
+		JobId:     job.ID,
+		Step:      step,
+	})
+}
@@ -0,0 +1,39 @@
+package builder
+
+import (
+	"fmt"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/shell"
+	"path/filepath"
+)
+
+func (b *Builder) executor(project *configMap.Project, buildDir string) (shell.Executor, error) {
+	sandbox := project.Sandbox
+
+	switch sandbox.Runtime {
+	case "", shell.RuntimeHost:
+		return &shell.HostExecutor{}, nil
+	case shell.RuntimePodman, shell.RuntimeDocker:
+		return &shell.ContainerExecutor{
+			Runtime:  sandbox.Runtime,
+			Image:    sandbox.Image,
+			Cpus:     sandbox.Cpus,
+			Memory:   sandbox.Memory,
+			BuildDir: buildDir,
+		}, nil
+	case shell.RuntimeBubblewrap:
+		var hiddenPaths []string
+		for _, hiddenPath := range []string{b.configMap.Path, b.configMap.DBPath, b.configMap.StoragePath} {
+			if absPath, err := filepath.Abs(hiddenPath); err == nil && len(hiddenPath) > 0 {
+				hiddenPaths = append(hiddenPaths, absPath)
+			}
+		}
+
+		return &shell.BubblewrapExecutor{
+			BuildDir:    buildDir,
+			HiddenPaths: hiddenPaths,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown sandbox runtime `%s` of project %s", sandbox.Runtime, project.ProjectID)
+}
@@ -0,0 +1,68 @@
+package builder
+
+import (
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/shell"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// gitTokenEnv keeps gitlab token out of git arguments, credential helper reads it from environment of fetch
+const gitTokenEnv = "MFE_GIT_TOKEN"
+
+// gitCredentialHelper answers only `get` requests, so git never tries to store token anywhere
+const gitCredentialHelper = `!f() { test "$1" = get && echo username=oauth2 && echo "password=$` + gitTokenEnv + `"; }; f`
+
+// gitRemote describes how to fetch repository of project, url never contains credentials
+type gitRemote struct {
+	url   string
+	label string
+	// args are passed to git before subcommand
+	args []string
+	env  []string
+}
+
+func (b *Builder) gitRemote(project *configMap.Project, gitProject *gitlab.Project) (*gitRemote, error) {
+	remote := &gitRemote{
+		label: fmt.Sprintf("%s/%s", gitProject.Namespace.FullPath, gitProject.Name),
+		env:   []string{"GIT_TERMINAL_PROMPT=0"},
+	}
+
+	if deployKey := project.DeployKey; len(deployKey.PrivateKeyPath) > 0 {
+		if len(gitProject.SSHURLToRepo) == 0 {
+			return nil, fmt.Errorf("gitlab returned no ssh url of project %s", project.ProjectID)
+		}
+
+		sshCommand := []string{"ssh", "-i", shell.Quote(deployKey.PrivateKeyPath), "-o", "IdentitiesOnly=yes", "-o", "BatchMode=yes"}
+		if len(deployKey.KnownHostsPath) > 0 {
+			sshCommand = append(sshCommand, "-o", "UserKnownHostsFile="+shell.Quote(deployKey.KnownHostsPath), "-o", "StrictHostKeyChecking=yes")
+		} else {
+			sshCommand = append(sshCommand, "-o", "StrictHostKeyChecking=accept-new")
+		}
+
+		remote.url = gitProject.SSHURLToRepo
+		remote.env = append(remote.env, "GIT_SSH_COMMAND="+strings.Join(sshCommand, " "))
+		return remote, nil
+	}
+
+	gitlabUrl, err := url.Parse(b.configMap.GitlabUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// gitlab could be served under sub path, so path of repo is joined to path of instance url
+	gitlabUrl.User = nil
+	gitlabUrl.RawQuery = ""
+	gitlabUrl.Fragment = ""
+	gitlabUrl.Path = path.Join("/", gitlabUrl.Path, gitProject.Namespace.FullPath, gitProject.Name+".git")
+	gitlabUrl.RawPath = ""
+
+	// empty helper resets helpers of worker user config, e.g. credential store which would save token on disk
+	remote.url = gitlabUrl.String()
+	remote.args = []string{"-c", "credential.helper=", "-c", "credential.helper=" + gitCredentialHelper}
+	remote.env = append(remote.env, gitTokenEnv+"="+b.configMap.GitlabToken)
+	return remote, nil
+}
@@ -0,0 +1,247 @@
+package builder
+
+import (
+	"log"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/shell"
+	"sync"
+	"time"
+)
+
+const (
+	// logBufferSize bounds lines which wait for insert, command output is blocked when database can't keep up
+	logBufferSize    = 1024
+	logBatchSize     = 100
+	logFlushInterval = 200 * time.Millisecond
+
+	// subscriberBuffer fits lines of the largest insert, so reader which keeps up with inserts doesn't miss lines
+	subscriberBuffer = logBufferSize + logBatchSize
+)
+
+// logWriter inserts lines of one build in batches from own goroutine, so commands don't wait for database on every line
+type logWriter struct {
+	mu      sync.RWMutex
+	closed  bool
+	lines   chan dbDriver.BuildLog
+	flushes chan chan struct{}
+	done    chan struct{}
+}
+
+// add blocks while buffer is full, false is returned when writer was closed already
+func (w *logWriter) add(buildLog dbDriver.BuildLog) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return false
+	}
+
+	w.lines <- buildLog
+	return true
+}
+
+// flush returns when all added lines are inserted and published
+func (w *logWriter) flush() {
+	flushed := make(chan struct{})
+
+	select {
+	case w.flushes <- flushed:
+		<-flushed
+	case <-w.done:
+	}
+}
+
+func (w *logWriter) close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.lines)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+}
+
+type logHub struct {
+	mu          sync.Mutex
+	dbDriver    *dbDriver.DBDriver
+	running     map[uint]bool
+	writers     map[uint]*logWriter
+	subscribers map[uint][]chan dbDriver.BuildLog
+}
+
+func (h *logHub) openBuild(buildId uint) {
+	writer := &logWriter{
+		lines:   make(chan dbDriver.BuildLog, logBufferSize),
+		flushes: make(chan chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	h.running[buildId] = true
+	h.writers[buildId] = writer
+	h.mu.Unlock()
+
+	go h.write(writer)
+}
+
+// write collects lines to batches, batch is inserted when it is full, by timer, on flush and on close
+func (h *logHub) write(writer *logWriter) {
+	defer close(writer.done)
+
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]dbDriver.BuildLog, 0, logBatchSize)
+
+	for {
+		select {
+		case buildLog, ok := <-writer.lines:
+			if !ok {
+				h.insert(batch)
+				return
+			}
+
+			batch = append(batch, buildLog)
+			if len(batch) >= logBatchSize {
+				batch = h.insert(batch)
+			}
+		case <-ticker.C:
+			batch = h.insert(batch)
+		case flushed := <-writer.flushes:
+			// lines which were added before flush are already in buffer
+			for len(writer.lines) > 0 {
+				batch = append(batch, <-writer.lines)
+			}
+
+			batch = h.insert(batch)
+			close(flushed)
+		}
+	}
+}
+
+// insert saves and publishes batch, emptied batch is returned for reuse
+func (h *logHub) insert(batch []dbDriver.BuildLog) []dbDriver.BuildLog {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := h.dbDriver.CreateBuildLogs(batch); err != nil {
+		log.Printf("failed on save %d build log lines: %s", len(batch), err)
+		return batch[:0]
+	}
+
+	for _, buildLog := range batch {
+		h.publish(buildLog)
+	}
+
+	return batch[:0]
+}
+
+func (h *logHub) writer(buildId uint) *logWriter {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.writers[buildId]
+}
+
+// flush waits until lines of build are saved, so final state of build is not saved before its last lines
+func (h *logHub) flush(buildId uint) {
+	if writer := h.writer(buildId); writer != nil {
+		writer.flush()
+	}
+}
+
+func (h *logHub) subscribe(buildId uint) chan dbDriver.BuildLog {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan dbDriver.BuildLog, subscriberBuffer)
+	if !h.running[buildId] {
+		close(ch)
+		return ch
+	}
+
+	h.subscribers[buildId] = append(h.subscribers[buildId], ch)
+
+	return ch
+}
+
+func (h *logHub) unsubscribe(buildId uint, ch chan dbDriver.BuildLog) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for index, item := range h.subscribers[buildId] {
+		if item == ch {
+			h.subscribers[buildId] = append(h.subscribers[buildId][:index], h.subscribers[buildId][index+1:]...)
+			close(ch)
+			break
+		}
+	}
+}
+
+func (h *logHub) publish(buildLog dbDriver.BuildLog) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, ch := range h.subscribers[buildLog.BuildId] {
+		select {
+		case ch <- buildLog:
+		default:
+			// slow reader must not block build, it will miss the line
+		}
+	}
+}
+
+// closeBuild saves rest of lines before subscribers are closed, so they get every line of build
+func (h *logHub) closeBuild(buildId uint) {
+	if writer := h.writer(buildId); writer != nil {
+		writer.close()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, ch := range h.subscribers[buildId] {
+		close(ch)
+	}
+
+	delete(h.subscribers, buildId)
+	delete(h.writers, buildId)
+	delete(h.running, buildId)
+}
+
+// SubscribeLogs returns channel with new log lines of build, channel is closed when build is not running or finished
+func (b *Builder) SubscribeLogs(buildId uint) (<-chan dbDriver.BuildLog, func()) {
+	ch := b.logs.subscribe(buildId)
+	return ch, func() { b.logs.unsubscribe(buildId, ch) }
+}
+
+func (b *Builder) logLines(build *dbDriver.Build, command string) shell.LineHandler {
+	return func(stream shell.Stream, line string) {
+		buildLog := dbDriver.BuildLog{
+			BuildId: build.ID,
+			Command: command,
+			Stream:  string(stream),
+			Line:    b.mask(build.ID, line),
+		}
+
+		if writer := b.logs.writer(build.ID); writer != nil && writer.add(buildLog) {
+			return
+		}
+
+		// line of build which is not running has no readers, it is only saved
+		if _, err := b.dbDriver.CreateBuildLog(&buildLog); err != nil {
+			log.Printf("failed on save build log: %s", err)
+		}
+	}
+}
+
+func newLogHub(db *dbDriver.DBDriver) *logHub {
+	return &logHub{
+		dbDriver:    db,
+		running:     map[uint]bool{},
+		writers:     map[uint]*logWriter{},
+		subscribers: map[uint][]chan dbDriver.BuildLog{},
+	}
+}
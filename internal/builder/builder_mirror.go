@@ -0,0 +1,155 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/shell"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	mirrorFetchAttempts = 3
+	mirrorRetryDelay    = 3 * time.Second
+)
+
+// mirrorRefSpecs keeps only branches and tags in mirror, gitlab also exposes refs of merge requests and pipelines
+var mirrorRefSpecs = []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+
+// mirrorLock returns lock of project mirror, it is channel with one slot, so waiting for it could be cancelled by context
+func (b *Builder) mirrorLock(projectId string) chan struct{} {
+	b.mirrorMu.Lock()
+	defer b.mirrorMu.Unlock()
+
+	lock, ok := b.mirrorLocks[projectId]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		b.mirrorLocks[projectId] = lock
+	}
+
+	return lock
+}
+
+// cloneFromMirror fetches new commits into bare mirror of project and makes local clone of it into dir,
+// local clone hardlinks objects, so build tree doesn't depend on mirror after clone
+func (b *Builder) cloneFromMirror(ctx context.Context, project *configMap.Project, build *dbDriver.Build, remote *gitRemote, dir string) error {
+	// cancelled build doesn't wait for long fetch of other build of project
+	lock := b.mirrorLock(project.ProjectID)
+	select {
+	case lock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	defer func() { <-lock }()
+
+	mirrorPath, err := filepath.Abs(b.fsDriver.GetProjectMirrorPath(project.ProjectID))
+	if err != nil {
+		return err
+	}
+
+	err = b.fetchMirror(ctx, build, remote, mirrorPath)
+	for attempt := 2; err != nil && ctx.Err() == nil && attempt <= mirrorFetchAttempts; attempt++ {
+		if err := b.repairMirror(ctx, build, remote, mirrorPath); err != nil {
+			return err
+		}
+
+		// network and auth errors are retried with the same mirror, fetch downloads only missing objects
+		select {
+		case <-ctx.Done():
+		case <-time.After(mirrorRetryDelay):
+		}
+
+		err = b.fetchMirror(ctx, build, remote, mirrorPath)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	cloneArgs := []string{"clone", "--local", "--no-checkout", mirrorPath, dir}
+	cloneLabel := fmt.Sprintf("git clone --local --no-checkout %s", remote.label)
+	cloneExecArgs := shell.ExecShellCommandArgs{OnLine: b.logLines(build, cloneLabel)}
+
+	if _, err := shell.ExecShellCommand(ctx, "git", cloneArgs, cloneExecArgs); err != nil {
+		return errors.Join(fmt.Errorf("failed on clone project from mirror: %s", cloneLabel), err)
+	}
+
+	// origin of checkout points to gitlab without credentials, build scripts see neither token nor mirror path
+	originArgs := []string{"remote", "set-url", "origin", remote.url}
+	originExecArgs := shell.ExecShellCommandArgs{Cwd: dir, OnLine: b.logLines(build, "git remote set-url origin "+remote.label)}
+
+	if _, err := shell.ExecShellCommand(ctx, "git", originArgs, originExecArgs); err != nil {
+		return errors.Join(fmt.Errorf("failed on set origin of project: %s", remote.label), err)
+	}
+
+	return nil
+}
+
+// repairMirror removes lock files left by killed fetch, only mirror which fails fsck is removed for clone from scratch
+func (b *Builder) repairMirror(ctx context.Context, build *dbDriver.Build, remote *gitRemote, mirrorPath string) error {
+	if !b.fsDriver.IsDirExists(mirrorPath) {
+		return nil
+	}
+
+	// mirror lock is held, so no other git process works with mirror and its locks are stale
+	err := filepath.WalkDir(mirrorPath, func(walkPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() && entry.Name() == "objects" {
+			return filepath.SkipDir
+		}
+
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".lock") {
+			log.Printf("remove stale lock of mirror: %s", walkPath)
+			return os.Remove(walkPath)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	fsckArgs := []string{"fsck", "--connectivity-only", "--no-dangling"}
+	fsckExecArgs := shell.ExecShellCommandArgs{Cwd: mirrorPath, OnLine: b.logLines(build, "git fsck "+remote.label)}
+
+	if _, err := shell.ExecShellCommand(ctx, "git", fsckArgs, fsckExecArgs); err != nil && ctx.Err() == nil {
+		log.Printf("mirror of %s is corrupted and will be cloned again: %s", remote.label, err)
+		return os.RemoveAll(mirrorPath)
+	}
+
+	return nil
+}
+
+func (b *Builder) fetchMirror(ctx context.Context, build *dbDriver.Build, remote *gitRemote, mirrorPath string) error {
+	if !b.fsDriver.IsDirExists(mirrorPath) {
+		initArgs := []string{"init", "--bare", mirrorPath}
+		initExecArgs := shell.ExecShellCommandArgs{OnLine: b.logLines(build, "git init --bare "+remote.label)}
+
+		if _, err := shell.ExecShellCommand(ctx, "git", initArgs, initExecArgs); err != nil {
+			return errors.Join(fmt.Errorf("failed on init mirror of project: %s", remote.label), err)
+		}
+	}
+
+	// remote url is passed on each fetch instead of saving it in mirror config
+	fetchArgs := append(append(remote.args, "fetch", "--prune", "--force", remote.url), mirrorRefSpecs...)
+	fetchLabel := fmt.Sprintf("git fetch --prune %s", remote.label)
+	fetchExecArgs := shell.ExecShellCommandArgs{Cwd: mirrorPath, OnLine: b.logLines(build, fetchLabel), Env: remote.env}
+
+	if _, err := shell.ExecShellCommand(ctx, "git", fetchArgs, fetchExecArgs); err != nil {
+		return errors.Join(fmt.Errorf("failed on fetch mirror of project: %s", fetchLabel), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package builder
+
+import (
+	"fmt"
+	"mfe-worker/internal/configMap"
+	"sort"
+	"strings"
+)
+
+const maskedValue = "***"
+
+// minMaskedLineLength skips short lines of multi-line secrets like `{` of json, masking them would hide common text of logs
+const minMaskedLineLength = 4
+
+// buildEnv returns variables of build commands in KEY=VALUE form, secrets override plain variables of the same name
+func buildEnv(project *configMap.Project, secrets map[string]string) []string {
+	values := make(map[string]string, len(project.Env)+len(secrets))
+	for name, value := range project.Env {
+		values[name] = value
+	}
+
+	for name, value := range secrets {
+		values[name] = value
+	}
+
+	env := make([]string, 0, len(values))
+	for name, value := range values {
+		env = append(env, fmt.Sprintf("%s=%s", name, value))
+	}
+
+	sort.Strings(env)
+	return env
+}
+
+// setMasks keeps secret values of running build, gitlab token is always masked
+func (b *Builder) setMasks(buildId uint, secrets map[string]string) {
+	values := maskedValues(b.configMap.GitlabToken)
+	for _, value := range secrets {
+		values = append(values, maskedValues(value)...)
+	}
+
+	// replacer tries values in order, longer secret must win over secret which is prefix of it
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	var pairs []string
+	for _, value := range values {
+		if len(value) > 0 {
+			pairs = append(pairs, value, maskedValue)
+		}
+	}
+
+	b.masksMu.Lock()
+	defer b.masksMu.Unlock()
+	b.masks[buildId] = strings.NewReplacer(pairs...)
+}
+
+// maskedValues returns secret and each line of multi-line secret, logs are masked line by line,
+// so whole value of key or certificate never matches
+func maskedValues(secret string) []string {
+	values := []string{secret}
+	if !strings.Contains(secret, "\n") {
+		return values
+	}
+
+	for _, line := range strings.Split(secret, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) >= minMaskedLineLength {
+			values = append(values, line)
+		}
+	}
+
+	return values
+}
+
+func (b *Builder) clearMasks(buildId uint) {
+	b.masksMu.Lock()
+	defer b.masksMu.Unlock()
+	delete(b.masks, buildId)
+}
+
+// mask hides secrets of build in text which goes to build logs, build errors and events
+func (b *Builder) mask(buildId uint, text string) string {
+	b.masksMu.RLock()
+	replacer, ok := b.masks[buildId]
+	b.masksMu.RUnlock()
+
+	if !ok {
+		if len(b.configMap.GitlabToken) == 0 {
+			return text
+		}
+
+		return strings.ReplaceAll(text, b.configMap.GitlabToken, maskedValue)
+	}
+
+	return replacer.Replace(text)
+}
+
+func (b *Builder) masker(buildId uint) func(text string) string {
+	return func(text string) string {
+		return b.mask(buildId, text)
+	}
+}
+
+// maskedError keeps chain of original error for errors.Is and errors.As, only text is masked
+type maskedError struct {
+	text string
+	err  error
+}
+
+func (e *maskedError) Error() string {
+	return e.text
+}
+
+func (e *maskedError) Unwrap() error {
+	return e.err
+}
+
+func (b *Builder) maskError(buildId uint, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	text := b.mask(buildId, err.Error())
+	if text == err.Error() {
+		return err
+	}
+
+	return &maskedError{text: text, err: err}
+}
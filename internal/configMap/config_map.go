@@ -1,11 +1,15 @@
 package configMap
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/samber/lo"
 	"log"
 	"os"
+	"path"
+	"strings"
 )
 
 func (ctx *ConfigMap) ReadFromFileSystem() error {
@@ -43,9 +47,83 @@ func (ctx *ConfigMap) ReadFromFileSystem() error {
 		return errors.Join(errors.New("failed on parse configuration file from JSON"), err)
 	}
 
+	ctx.Path = defaultPlacePath
 	return nil
 }
 
+func (ctx *ConfigMap) FindProject(projectId string) *Project {
+	for index := range ctx.Projects {
+		if ctx.Projects[index].ProjectID == projectId {
+			return &ctx.Projects[index]
+		}
+	}
+
+	return nil
+}
+
+func (ctx *ConfigMap) FindApiToken(token string) *ApiToken {
+	for index := range ctx.ApiTokens {
+		if subtle.ConstantTimeCompare([]byte(ctx.ApiTokens[index].Token), []byte(token)) == 1 {
+			return &ctx.ApiTokens[index]
+		}
+	}
+
+	return nil
+}
+
+func (t *ApiToken) HasScope(scope string) bool {
+	return lo.Contains(t.Scopes, ScopeAdmin) || lo.Contains(t.Scopes, scope)
+}
+
+func (p *Project) IsBranchAllowed(branch string) bool {
+	return len(p.Branches) == 0 || lo.Contains(p.Branches, branch)
+}
+
+func (p *Project) IsTagAllowed(tag string) bool {
+	return len(p.Tags) == 0 || lo.Contains(p.Tags, tag)
+}
+
+// IsPriorityBranch reports whether jobs of branch go to priority lane of queue
+func (p *Project) IsPriorityBranch(branch string) bool {
+	if len(p.DefaultBranch) > 0 && p.DefaultBranch == branch {
+		return true
+	}
+
+	return lo.SomeBy(p.PriorityBranches, func(pattern string) bool {
+		matched, err := path.Match(pattern, branch)
+		return err == nil && matched
+	})
+}
+
+// ResolveSecrets reads values of `file:` and `env:` references of project secrets, other values are used as is
+func (p *Project) ResolveSecrets() (map[string]string, error) {
+	secrets := make(map[string]string, len(p.Secrets))
+
+	for name, value := range p.Secrets {
+		switch {
+		case strings.HasPrefix(value, SecretFilePrefix):
+			content, err := os.ReadFile(strings.TrimPrefix(value, SecretFilePrefix))
+			if err != nil {
+				return nil, errors.Join(fmt.Errorf("failed on read secret %s", name), err)
+			}
+
+			secrets[name] = strings.TrimRight(string(content), "\r\n")
+		case strings.HasPrefix(value, SecretEnvPrefix):
+			envName := strings.TrimPrefix(value, SecretEnvPrefix)
+			envValue, ok := os.LookupEnv(envName)
+			if !ok {
+				return nil, fmt.Errorf("variable %s of secret %s is not set in worker environment", envName, name)
+			}
+
+			secrets[name] = envValue
+		default:
+			secrets[name] = value
+		}
+	}
+
+	return secrets, nil
+}
+
 func NewConfigMap() (*ConfigMap, error) {
 	var configMap ConfigMap
 	return &configMap, configMap.ReadFromFileSystem()
@@ -1,16 +1,86 @@
 package configMap
 
+import (
+	"mfe-worker/internal/shell"
+	"time"
+)
+
 var ConfigTemplate = ConfigMap{
 	HttpBaseUrl: "[base http url: ex: http://localhost:3433]",
 	DBPath:      "[path for save sqlite db file, ex: mf_worker.db]",
 	GitlabUrl:   "[base gitlab instance url]",
 	GitlabToken: "[gitlab token with access to read projects]",
 	StoragePath: "[path to dir for store build assets]",
+	ApiTokens: []ApiToken{{
+		Name:   "[token owner, used in logs]",
+		Token:  "[random secret passed as `Authorization: Bearer <token>`]",
+		Scopes: []string{"[any of: read, build, admin]", ScopeRead, ScopeBuild},
+	}},
+	CorsOrigins: []string{"*"},
+	StaticAuth:  false,
+	GcInterval:  Duration{time.Hour},
+	Workers:     5,
+
+	BranchSyncInterval: Duration{time.Hour},
+	BranchGracePeriod:  Duration{24 * time.Hour},
 	Projects: []Project{{
 		Branches:      []string{"[branches white list or empty array for pass all names]"},
+		Tags:          []string{"[tags white list or empty array for pass all names]"},
+		DefaultBranch: "[branch used in aggregated import map when requested branch has no builds, ex: main]",
 		ProjectID:     "[project id of gitlab]",
 		DistFiles:     []string{"[files what need to save after build and share]", "dist/app.js", "dist/app.css"},
+		EntryFile:     "[entry js file for import map, empty for first js of dist files, ex: dist/app.js]",
 		ProjectName:   "[project name (any value, not gitlab name)]",
-		BuildCommands: []string{"[commands for build project after clone]", "npm run prebuild", "npm run build"},
+		BuildCommands: []shell.Command{
+			{Run: "[commands for build project after clone, string is split into args or runs through sh when it has pipes, operators or $VARS, globs are passed as is]"},
+			{Run: "set -o pipefail; npm run prebuild | tee prebuild.log", Shell: "bash"},
+			{Cmd: "npm", Args: []string{"run", "build"}, Cwd: "[dir of repo, empty for repo root]", Env: map[string]string{"NODE_ENV": "production"}},
+		},
+		WebhookToken: "[secret token of gitlab webhook (X-Gitlab-Token), empty for disable webhooks]",
+		Retention: Retention{
+			KeepLast: 10,
+			MaxAge:   Duration{30 * 24 * time.Hour},
+		},
+		Sandbox: Sandbox{
+			Runtime: "[where build commands are executed: host, podman, docker or bwrap]",
+			Image:   "[container image for podman or docker, ex: node:18-alpine]",
+			Cpus:    "[cpus limit of container, ex: 2]",
+			Memory:  "[memory limit of container, ex: 2g]",
+		},
+		Cache: Cache{
+			Paths:     []string{"[dirs of repo kept between builds with the same lockfiles, empty for disable cache]", "node_modules"},
+			KeyFiles:  []string{"[files which content is cache key, empty for package-lock.json, yarn.lock and pnpm-lock.yaml]"},
+			MaxSizeMb: 2048,
+		},
+		DeployKey: DeployKey{
+			PrivateKeyPath: "[ssh private key of gitlab deploy key, empty for fetch over http with gitlab token]",
+			KnownHostsPath: "[known_hosts file with gitlab host key, empty for trust host key on first fetch]",
+		},
+		BuildTimeout:   Duration{30 * time.Minute},
+		CommandTimeout: Duration{10 * time.Minute},
+
+		PriorityBranches:    []string{"[branches or glob patterns built ahead of other branches, default branch is always included]", "main", "release/*"},
+		MaxConcurrentBuilds: 2,
+		CancelSuperseded:    false,
+
+		Env: map[string]string{
+			"[variable of build commands]": "[value]",
+			"API_URL":                      "https://api.example.com",
+		},
+		Secrets: map[string]string{
+			"[variable of build commands, value is masked in logs]": "[value, file:<path> or env:<worker variable>]",
+			"NPM_TOKEN": "file:/run/secrets/npm_token",
+		},
+
+		Notifications: []Notification{{
+			Type:   "[format of notification: webhook, slack or mattermost]",
+			Url:    "[url of receiver or incoming webhook]",
+			Secret: "[secret for X-Mfe-Signature HMAC of webhook body, empty for unsigned]",
+			Events: []string{"[build results to notify about, empty for all]", "succeeded", "failed"},
+		}},
+		GitlabReport: GitlabReport{
+			CommitStatus:     true,
+			MergeRequestNote: false,
+		},
 	}},
 }
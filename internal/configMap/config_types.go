@@ -1,20 +1,138 @@
 package configMap
 
+import (
+	"encoding/json"
+	"mfe-worker/internal/shell"
+	"time"
+)
+
 var configPlaces = [...]string{".mfe-worker.json", "~/.mfe-worker.json"}
 
+const (
+	ScopeRead  = "read"
+	ScopeBuild = "build"
+	ScopeAdmin = "admin"
+)
+
+const (
+	SecretFilePrefix = "file:"
+	SecretEnvPrefix  = "env:"
+)
+
+// Duration is time.Duration which is written in config as string, ex: "72h"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	if len(value) == 0 {
+		d.Duration = 0
+		return nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+type Retention struct {
+	KeepLast int      `json:"keep_last"`
+	MaxAge   Duration `json:"max_age"`
+}
+
+type Cache struct {
+	Paths     []string `json:"paths"`
+	KeyFiles  []string `json:"key_files"`
+	MaxSizeMb int      `json:"max_size_mb"`
+}
+
+type Sandbox struct {
+	Runtime string `json:"runtime"`
+	Image   string `json:"image"`
+	Cpus    string `json:"cpus"`
+	Memory  string `json:"memory"`
+}
+
+// DeployKey is used instead of gitlab token to fetch repository of project over ssh
+type DeployKey struct {
+	PrivateKeyPath string `json:"private_key_path"`
+	KnownHostsPath string `json:"known_hosts_path"`
+}
+
+type Notification struct {
+	Type   string   `json:"type"`
+	Url    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+type GitlabReport struct {
+	CommitStatus     bool `json:"commit_status"`
+	MergeRequestNote bool `json:"merge_request_note"`
+}
+
+type ApiToken struct {
+	Name   string   `json:"name"`
+	Token  string   `json:"token"`
+	Scopes []string `json:"scopes"`
+}
+
 type Project struct {
-	Branches      []string `json:"branches"`
-	ProjectID     string   `json:"project_id"`
-	DistFiles     []string `json:"dist_files"`
-	ProjectName   string   `json:"project_name"`
-	BuildCommands []string `json:"build_commands"`
+	Branches       []string        `json:"branches"`
+	Tags           []string        `json:"tags"`
+	DefaultBranch  string          `json:"default_branch"`
+	ProjectID      string          `json:"project_id"`
+	DistFiles      []string        `json:"dist_files"`
+	EntryFile      string          `json:"entry_file"`
+	ProjectName    string          `json:"project_name"`
+	BuildCommands  []shell.Command `json:"build_commands"`
+	WebhookToken   string          `json:"webhook_token"`
+	Retention      Retention       `json:"retention"`
+	Sandbox        Sandbox         `json:"sandbox"`
+	Cache          Cache           `json:"cache"`
+	DeployKey      DeployKey       `json:"deploy_key"`
+	BuildTimeout   Duration        `json:"build_timeout"`
+	CommandTimeout Duration        `json:"command_timeout"`
+
+	PriorityBranches    []string `json:"priority_branches"`
+	MaxConcurrentBuilds int      `json:"max_concurrent_builds"`
+	CancelSuperseded    bool     `json:"cancel_superseded"`
+
+	// Env and Secrets are passed to build commands, value of secret could be `file:<path>` or `env:<name>` reference
+	Env     map[string]string `json:"env"`
+	Secrets map[string]string `json:"secrets"`
+
+	Notifications []Notification `json:"notifications"`
+	GitlabReport  GitlabReport   `json:"gitlab_report"`
 }
 
 type ConfigMap struct {
-	HttpBaseUrl string    `json:"http_base_url"`
-	DBPath      string    `json:"db_path"`
-	Projects    []Project `json:"projects"`
-	GitlabUrl   string    `json:"gitlab_url"`
-	GitlabToken string    `json:"gitlab_token"`
-	StoragePath string    `json:"storage_path"`
+	Path        string     `json:"-"`
+	HttpBaseUrl string     `json:"http_base_url"`
+	DBPath      string     `json:"db_path"`
+	Projects    []Project  `json:"projects"`
+	GitlabUrl   string     `json:"gitlab_url"`
+	GitlabToken string     `json:"gitlab_token"`
+	StoragePath string     `json:"storage_path"`
+	ApiTokens   []ApiToken `json:"api_tokens"`
+	CorsOrigins []string   `json:"cors_origins"`
+	StaticAuth  bool       `json:"static_auth"`
+	GcInterval  Duration   `json:"gc_interval"`
+	Workers     int        `json:"workers"`
+
+	BranchSyncInterval Duration `json:"branch_sync_interval"`
+	BranchGracePeriod  Duration `json:"branch_grace_period"`
 }
@@ -6,8 +6,15 @@ import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"mfe-worker/internal/configMap"
+	"time"
 )
 
+// ErrBuildActive is returned by deletes which found queued or running build of revision
+var ErrBuildActive = errors.New("build of revision is queued or in progress")
+
+// ErrBranchNotExpired is returned by DeleteExpiredBranch when branch was pushed again or marked as removed later
+var ErrBranchNotExpired = errors.New("branch is not expired")
+
 type DBDriver struct {
 	db        *gorm.DB
 	configMap *configMap.ConfigMap
@@ -27,19 +34,69 @@ func (d *DBDriver) DeleteBranch(branch *Branch) error {
 	return d.db.Delete(branch).Error
 }
 
+// SetBranchRemovedAt marks branch as removed from gitlab, nil value unmarks it
+func (d *DBDriver) SetBranchRemovedAt(branch *Branch, removedAt *time.Time) error {
+	branch.RemovedAt = removedAt
+	return d.db.Model(branch).Update("removed_at", removedAt).Error
+}
+
+// DeleteExpiredBranch soft deletes branch with all its revisions and their builds when it was marked as removed
+// before removedBefore, branch is read again in transaction, so stale copy of it doesn't remove branch which was pushed again,
+// ErrBranchNotExpired or ErrBuildActive is returned and nothing is deleted otherwise
+func (d *DBDriver) DeleteExpiredBranch(branch *Branch, removedBefore time.Time) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var current Branch
+		if err := tx.First(&current, branch.ID).Error; err != nil {
+			return err
+		}
+
+		if current.RemovedAt == nil || current.RemovedAt.After(removedBefore) {
+			return ErrBranchNotExpired
+		}
+
+		var revisions []Revision
+		if err := tx.Model(Revision{}).Where(Revision{BranchId: branch.ID}).Find(&revisions).Error; err != nil {
+			return err
+		}
+
+		revisionIds := make([]uint, 0, len(revisions))
+		for _, revision := range revisions {
+			revisionIds = append(revisionIds, revision.ID)
+		}
+
+		if err := ensureNoActiveBuilds(tx, revisionIds); err != nil {
+			return err
+		}
+
+		for index := range revisions {
+			if err := deleteRevisionWithBuild(tx, &revisions[index]); err != nil {
+				return err
+			}
+		}
+
+		return tx.Delete(&current).Error
+	})
+}
+
+// GetBranch returns branch with given name, tag of the same name is not returned
 func (d *DBDriver) GetBranch(projectId, name string) (*Branch, error) {
+	return d.GetRef(projectId, name, false)
+}
+
+// GetRef returns branch or tag with given name, branch and tag could have the same name, so kind is part of key
+func (d *DBDriver) GetRef(projectId, name string, tag bool) (*Branch, error) {
 	var branch *Branch
 
-	err := d.db.Model(Branch{}).Where(Branch{
-		Name:      name,
-		ProjectId: projectId,
-	}).Preload("Revisions").First(&branch).Error
+	err := d.db.Model(Branch{}).
+		Where("project_id = ? AND name = ? AND tag = ?", projectId, name, tag).
+		Preload("Revisions").
+		First(&branch).Error
 
 	if err != nil {
 		return nil, err
 	}
 
-	return branch, err
+	return branch, nil
 }
 
 // revisions
@@ -56,6 +113,72 @@ func (d *DBDriver) DeleteRevision(revision *Revision) error {
 	return d.db.Delete(revision).Error
 }
 
+// DeleteRevisionWithBuild soft deletes revision with its build, build files and logs,
+// ErrBuildActive is returned and nothing is deleted when build of revision is queued or running
+func (d *DBDriver) DeleteRevisionWithBuild(revision *Revision) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureNoActiveBuilds(tx, []uint{revision.ID}); err != nil {
+			return err
+		}
+
+		return deleteRevisionWithBuild(tx, revision)
+	})
+}
+
+// ensureNoActiveBuilds reads states of builds in transaction of delete,
+// so build which was queued after its revision was picked for removal is kept
+func ensureNoActiveBuilds(tx *gorm.DB, revisionIds []uint) error {
+	var count int64
+
+	err := tx.Model(Build{}).
+		Where("revision_id IN ? AND status IN ?", revisionIds, []BuildStatus{BuildStatusQueued, BuildStatusInProgress}).
+		Count(&count).Error
+
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrBuildActive
+	}
+
+	return nil
+}
+
+func deleteRevisionWithBuild(tx *gorm.DB, revision *Revision) error {
+	var buildIds []uint
+	if err := tx.Model(Build{}).Where(Build{RevisionId: revision.ID}).Pluck("id", &buildIds).Error; err != nil {
+		return err
+	}
+
+	if len(buildIds) > 0 {
+		if err := tx.Where("build_id IN ?", buildIds).Delete(&BuildFiles{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("build_id IN ?", buildIds).Delete(&BuildLog{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&Build{}, buildIds).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Delete(revision).Error
+}
+
+func (d *DBDriver) GetRevision(id uint) (*Revision, error) {
+	var revision *Revision
+
+	err := d.db.Model(Revision{}).First(&revision, id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
 // builds
 
 func (d *DBDriver) CreateBuild(build *Build) (*Build, error) {
@@ -63,13 +186,153 @@ func (d *DBDriver) CreateBuild(build *Build) (*Build, error) {
 }
 
 func (d *DBDriver) UpdateBuild(build *Build) (*Build, error) {
-	return build, d.db.Updates(&build).Error
+	return build, d.db.Save(build).Error
 }
 
 func (d *DBDriver) DeleteBuild(build *Build) error {
 	return d.db.Delete(build).Error
 }
 
+func (d *DBDriver) GetBuildByRevision(revisionId uint) (*Build, error) {
+	var build *Build
+
+	err := d.db.Model(Build{}).Where(Build{RevisionId: revisionId}).First(&build).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return build, nil
+}
+
+// QueueRebuild moves build of revision to queued state with one conditional update and drops its files and logs,
+// so only one of concurrent rebuilds claims build, ErrBuildActive is returned when build is already queued or running.
+// Rebuilds counter is increased, so files of rebuild are published to new dir
+func (d *DBDriver) QueueRebuild(revisionId uint) (*Build, error) {
+	var build *Build
+
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(Build{}).
+			Where("revision_id = ? AND status NOT IN ?", revisionId, []BuildStatus{BuildStatusQueued, BuildStatusInProgress}).
+			Updates(map[string]any{
+				"status":        BuildStatusQueued,
+				"rebuilds":      gorm.Expr("rebuilds + 1"),
+				"failed_step":   "",
+				"exit_code":     0,
+				"error_message": "",
+			})
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		err := tx.Model(Build{}).Where(Build{RevisionId: revisionId}).First(&build).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// unique index of revision fails concurrent create of the same build
+			build = &Build{Status: BuildStatusQueued, RevisionId: revisionId}
+			return tx.Create(build).Error
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrBuildActive
+		}
+
+		if err := tx.Where(BuildFiles{BuildId: build.ID}).Delete(&BuildFiles{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where(BuildLog{BuildId: build.ID}).Delete(&BuildLog{}).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return build, nil
+}
+
+func (d *DBDriver) DeleteBuildFiles(build *Build) error {
+	return d.db.Where(BuildFiles{BuildId: build.ID}).Delete(&BuildFiles{}).Error
+}
+
+// build logs
+
+func (d *DBDriver) CreateBuildLog(buildLog *BuildLog) (*BuildLog, error) {
+	return buildLog, d.db.Create(buildLog).Error
+}
+
+// CreateBuildLogs inserts lines with one statement, ids are set to given lines
+func (d *DBDriver) CreateBuildLogs(buildLogs []BuildLog) error {
+	return d.db.Create(&buildLogs).Error
+}
+
+func (d *DBDriver) DeleteBuildLogs(build *Build) error {
+	return d.db.Where(BuildLog{BuildId: build.ID}).Delete(&BuildLog{}).Error
+}
+
+func (d *DBDriver) GetBuildLogs(build *Build) (list []BuildLog, err error) {
+	err = d.db.Model(BuildLog{}).Where(BuildLog{BuildId: build.ID}).Order("id ASC").Find(&list).Error
+	return
+}
+
+// jobs
+
+func (d *DBDriver) CreateJob(job *Job) (*Job, error) {
+	return job, d.db.Create(job).Error
+}
+
+func (d *DBDriver) SaveJob(job *Job) (*Job, error) {
+	return job, d.db.Save(job).Error
+}
+
+func (d *DBDriver) GetJob(id uint) (*Job, error) {
+	var job *Job
+
+	err := d.db.Model(Job{}).First(&job, id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (d *DBDriver) GetUnfinishedJobs() (list []*Job, err error) {
+	err = d.db.Model(Job{}).
+		Where("status IN ?", []JobStatus{JobStatusQueued, JobStatusRunning}).
+		Order("id ASC").
+		Find(&list).Error
+
+	return
+}
+
+// notification deliveries
+
+func (d *DBDriver) CreateNotificationDelivery(delivery *NotificationDelivery) (*NotificationDelivery, error) {
+	return delivery, d.db.Create(delivery).Error
+}
+
+func (d *DBDriver) SaveNotificationDelivery(delivery *NotificationDelivery) (*NotificationDelivery, error) {
+	return delivery, d.db.Save(delivery).Error
+}
+
+func (d *DBDriver) GetJobNotificationDeliveries(jobId uint) (list []*NotificationDelivery, err error) {
+	err = d.db.Model(NotificationDelivery{}).Where(NotificationDelivery{JobId: jobId}).Order("id ASC").Find(&list).Error
+	return
+}
+
+// GetDueNotificationDeliveries returns pending deliveries which next attempt is not later than now
+func (d *DBDriver) GetDueNotificationDeliveries(now time.Time) (list []*NotificationDelivery, err error) {
+	err = d.db.Model(NotificationDelivery{}).
+		Where("delivered = ? AND failed = ? AND next_attempt_at <= ?", false, false, now).
+		Order("id ASC").
+		Find(&list).Error
+
+	return
+}
+
 func (d *DBDriver) GetBranches(projectId string, pagination Pagination) (list []Branch, total int64, err error) {
 	d.db.Model(Branch{}).Where(Branch{
 		ProjectId: projectId,
@@ -78,7 +341,18 @@ func (d *DBDriver) GetBranches(projectId string, pagination Pagination) (list []
 	return
 }
 
-func (d *DBDriver) GetRevisions(projectId, branch string, pagination Pagination) (list []Revision, total int64, err error) {
+func (d *DBDriver) GetAllBranches(projectId string) (list []Branch, err error) {
+	err = d.db.Model(Branch{}).Where(Branch{ProjectId: projectId}).
+		Preload("Revisions", func(db *gorm.DB) *gorm.DB {
+			return db.Order("revisions.id DESC")
+		}).
+		Preload("Revisions.Build").
+		Find(&list).Error
+
+	return
+}
+
+func (d *DBDriver) GetRevisions(projectId, branch string, tag bool, pagination Pagination) (list []Revision, total int64, err error) {
 	type TmpList struct {
 		*Revision
 		Total uint
@@ -95,7 +369,8 @@ func (d *DBDriver) GetRevisions(projectId, branch string, pagination Pagination)
 				FROM 
 					revisions r 
 				WHERE 
-					b.id = r.branch_id
+					b.id = r.branch_id 
+					AND r.deleted_at IS NULL
 			) as total 
 		FROM 
 			branches b 
@@ -103,11 +378,14 @@ func (d *DBDriver) GetRevisions(projectId, branch string, pagination Pagination)
 		WHERE
 			b.project_id = ? 
 			AND b.name = ? 
+			AND b.tag = ? 
+			AND b.deleted_at IS NULL 
+			AND r.deleted_at IS NULL 
 		ORDER BY 
 			b.id DESC 
 		LIMIT 
 			? OFFSET ?
-  `, projectId, branch, pagination.Limit, pagination.Offset).Scan(&tmpList).Error
+  `, projectId, branch, tag, pagination.Limit, pagination.Offset).Scan(&tmpList).Error
 
 	for _, r := range tmpList {
 		list = append(list, Revision{
@@ -123,6 +401,61 @@ func (d *DBDriver) GetRevisions(projectId, branch string, pagination Pagination)
 	return
 }
 
+// FindRefRevision returns revision of branch or tag, see GetRef
+func (d *DBDriver) FindRefRevision(projectId, name string, tag bool, revisionName string) (*Revision, error) {
+	branch, err := d.GetRef(projectId, name, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, revision := range branch.Revisions {
+		if revision.Name == revisionName {
+			revision := revision
+			return &revision, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (d *DBDriver) FindBuild(projectId, name string, tag bool, revisionName string) (*Build, error) {
+	revision, err := d.FindRefRevision(projectId, name, tag, revisionName)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetBuildByRevision(revision.ID)
+}
+
+func (d *DBDriver) GetLatestReadyBuild(projectId, name string, tag bool) (*Build, error) {
+	var build *Build
+
+	err := d.db.Model(Build{}).
+		Joins("JOIN revisions r ON r.id = builds.revision_id AND r.deleted_at IS NULL").
+		Joins("JOIN branches b ON b.id = r.branch_id AND b.deleted_at IS NULL").
+		Where("b.project_id = ? AND b.name = ? AND b.tag = ? AND builds.status = ?", projectId, name, tag, BuildStatusReady).
+		Order("builds.updated_at DESC").
+		Preload("Files").
+		First(&build).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return build, nil
+}
+
+func (d *DBDriver) GetBuildWithFiles(revisionId uint) (*Build, error) {
+	var build *Build
+
+	err := d.db.Model(Build{}).Where(Build{RevisionId: revisionId}).Preload("Files").First(&build).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return build, nil
+}
+
 func (d *DBDriver) GetBuilds(branch *Branch, revision string, pagination Pagination) (builds []Build, total int64, err error) {
 	var rev *Revision
 	for _, r := range branch.Revisions {
@@ -151,7 +484,7 @@ func NewDBDriver(configMap *configMap.ConfigMap) (*DBDriver, error) {
 		return nil, errors.Join(fmt.Errorf("failed on open sqlite db on path: %s", configMap.DBPath), err)
 	}
 
-	err = db.AutoMigrate(&Branch{}, &Revision{}, &BuildFiles{}, &Build{})
+	err = db.AutoMigrate(&Branch{}, &Revision{}, &BuildFiles{}, &Build{}, &Job{}, &BuildLog{}, &NotificationDelivery{})
 	if err != nil {
 		return nil, errors.Join(errors.New("failed on auto migrate db models"), err)
 	}
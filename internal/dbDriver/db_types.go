@@ -1,19 +1,37 @@
 package dbDriver
 
-import "time"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type BuildStatus uint
 
 const (
 	BuildStatusReady      BuildStatus = iota
 	BuildStatusInProgress             = iota
+	BuildStatusFailed                 = iota
+	BuildStatusCancelled              = iota
+	BuildStatusQueued                 = iota
+	BuildStatusSuperseded             = iota
+)
+
+type JobStatus uint
+
+const (
+	JobStatusQueued     JobStatus = iota
+	JobStatusRunning              = iota
+	JobStatusDone                 = iota
+	JobStatusFailed               = iota
+	JobStatusCancelled            = iota
+	JobStatusSuperseded           = iota
 )
 
 type Model struct {
-	ID        uint       `gorm:"primary_key" json:"-"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	DeletedAt *time.Time `json:"deleted_at"`
+	ID        uint           `gorm:"primary_key" json:"-"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 }
 
 type Pagination struct {
@@ -24,7 +42,9 @@ type Pagination struct {
 type Branch struct {
 	Model
 	Name      string     `json:"name"`
+	Tag       bool       `json:"tag"`
 	ProjectId string     `json:"project_id"`
+	RemovedAt *time.Time `json:"removed_at,omitempty"`
 	Revisions []Revision `json:"revisions,omitempty"`
 }
 
@@ -37,14 +57,63 @@ type Revision struct {
 
 type Build struct {
 	Model
-	Files      []BuildFiles `json:"files,omitempty"`
-	Status     BuildStatus  `json:"status,omitempty"`
-	RevisionId uint         `gorm:"index:unique_revision,unique" json:"revision_id"`
+	Files        []BuildFiles `json:"files,omitempty"`
+	Status       BuildStatus  `json:"status"`
+	RevisionId   uint         `gorm:"index:unique_revision,unique" json:"revision_id"`
+	Rebuilds     uint         `gorm:"not null;default:0" json:"rebuilds"`
+	FailedStep   string       `json:"failed_step,omitempty"`
+	ExitCode     int          `json:"exit_code,omitempty"`
+	ErrorMessage string       `json:"error_message,omitempty"`
 }
 
 type BuildFiles struct {
 	Model
-	Path    string `json:"path"`
-	WebPath string `json:"web_path"`
-	BuildId uint   `json:"build_id"`
+	Path      string `json:"path"`
+	WebPath   string `json:"web_path"`
+	Sha256    string `json:"sha256"`
+	Integrity string `json:"integrity"`
+	BuildId   uint   `json:"build_id"`
+}
+
+type Job struct {
+	Model
+	ProjectId  string     `gorm:"index" json:"project_id"`
+	Branch     string     `json:"branch"`
+	Commit     string     `json:"commit"`
+	RevisionId uint       `json:"revision_id"`
+	Tag        bool       `json:"tag"`
+	Pinned     bool       `json:"pinned"`
+	Status     JobStatus  `gorm:"index" json:"status"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+type BuildLog struct {
+	Model
+	BuildId uint   `gorm:"index" json:"build_id"`
+	Command string `json:"command"`
+	Stream  string `json:"stream"`
+	Line    string `json:"line"`
+}
+
+// NotificationDelivery is outbox row of notification, pending row is sent again until it is delivered or failed.
+// Body is signed when row is created, so secret of receiver is not stored
+type NotificationDelivery struct {
+	Model
+	ProjectId     string     `gorm:"index" json:"project_id"`
+	Branch        string     `json:"branch"`
+	Revision      string     `json:"revision"`
+	JobId         uint       `json:"job_id"`
+	Event         string     `json:"event"`
+	Type          string     `json:"type"`
+	Target        string     `json:"target"`
+	Url           string     `json:"-"`
+	Body          string     `json:"-"`
+	Signature     string     `json:"-"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at"`
+	StatusCode    int        `json:"status_code"`
+	Error         string     `json:"error"`
+	Delivered     bool       `json:"delivered"`
+	Failed        bool       `json:"failed"`
 }
@@ -2,23 +2,32 @@ package di
 
 import (
 	"github.com/xanzy/go-gitlab"
+	"mfe-worker/internal/builder"
 	"mfe-worker/internal/configMap"
 	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/events"
 	"mfe-worker/internal/fsDriver"
+	"mfe-worker/internal/gc"
 	"mfe-worker/internal/queue"
 )
 
 type Container struct {
 	Queue        *queue.Queue
+	Builder      *builder.Builder
+	GC           *gc.GC
+	Events       *events.Bus
 	FSDriver     *fsDriver.FSDriver
 	DBDriver     *dbDriver.DBDriver
 	ConfigMap    *configMap.ConfigMap
 	GitlabClient *gitlab.Client
 }
 
-func NewDIContainer(configMap *configMap.ConfigMap, queue *queue.Queue, fsDriver *fsDriver.FSDriver, dbDriver *dbDriver.DBDriver, gitlabClient *gitlab.Client) *Container {
+func NewDIContainer(configMap *configMap.ConfigMap, queue *queue.Queue, builder *builder.Builder, gc *gc.GC, events *events.Bus, fsDriver *fsDriver.FSDriver, dbDriver *dbDriver.DBDriver, gitlabClient *gitlab.Client) *Container {
 	return &Container{
 		Queue:        queue,
+		Builder:      builder,
+		GC:           gc,
+		Events:       events,
 		FSDriver:     fsDriver,
 		DBDriver:     dbDriver,
 		ConfigMap:    configMap,
@@ -0,0 +1,196 @@
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	TypeBuildQueued    = "build.queued"
+	TypeBuildStarted   = "build.started"
+	TypeBuildStep      = "build.step"
+	TypeBuildSucceeded = "build.succeeded"
+	TypeBuildFailed    = "build.failed"
+	TypeBuildCancelled = "build.cancelled"
+	TypeLatestChanged  = "latest.changed"
+)
+
+type Event struct {
+	ID        uint      `json:"id"`
+	Type      string    `json:"type"`
+	ProjectId string    `json:"project_id"`
+	Branch    string    `json:"branch"`
+	Tag       bool      `json:"tag,omitempty"`
+	Revision  string    `json:"revision"`
+	JobId     uint      `json:"job_id,omitempty"`
+	Step      string    `json:"step,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Filter selects events of subscriber, empty fields match any value
+type Filter struct {
+	ProjectId string
+	Branch    string
+}
+
+func (f Filter) Match(event Event) bool {
+	return (len(f.ProjectId) == 0 || f.ProjectId == event.ProjectId) &&
+		(len(f.Branch) == 0 || f.Branch == event.Branch)
+}
+
+// subscriberBuffer is size of channel of lossy subscriber
+const subscriberBuffer = 256
+
+// dropLogInterval limits log of dropped events of the same subscriber, first drop and every n-th one are logged
+const dropLogInterval = 100
+
+type subscriber struct {
+	filter  Filter
+	ch      chan Event
+	dropped uint64
+
+	// reliable subscriber never misses events, they wait in backlog until pump passes them to channel
+	reliable bool
+	backlog  []Event
+	wake     chan struct{}
+	done     chan struct{}
+}
+
+// Bus delivers build lifecycle events from queue and builder to any number of subscribers
+type Bus struct {
+	mu          sync.Mutex
+	lastId      uint
+	dropped     uint64
+	subscribers []*subscriber
+}
+
+// Publish never blocks, lossy subscriber misses events which don't fit to its buffer
+// and reliable one gets them later from backlog
+func (b *Bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastId++
+	event.ID = b.lastId
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for _, sub := range b.subscribers {
+		if !sub.filter.Match(event) {
+			continue
+		}
+
+		if sub.reliable {
+			sub.backlog = append(sub.backlog, event)
+			select {
+			case sub.wake <- struct{}{}:
+			default:
+			}
+
+			continue
+		}
+
+		select {
+		case sub.ch <- event:
+		default:
+			sub.dropped++
+			b.dropped++
+			if sub.dropped%dropLogInterval == 1 {
+				log.Printf("events subscriber is too slow, dropped %d events of it, last one is %s #%d", sub.dropped, event.Type, event.ID)
+			}
+		}
+	}
+}
+
+// Dropped returns count of events which were dropped for all lossy subscribers
+func (b *Bus) Dropped() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.dropped
+}
+
+// Subscribe returns channel of events matched by filter and func which closes it,
+// events are dropped while channel is full, so it fits for dashboards and streams to clients
+func (b *Bus) Subscribe(filter Filter) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscriber{filter: filter, ch: make(chan Event, subscriberBuffer)}
+	b.subscribers = append(b.subscribers, sub)
+
+	return sub.ch, func() { b.unsubscribe(sub) }
+}
+
+// SubscribeReliable returns channel which gets every event matched by filter in order of publish,
+// slow reader delays only own events, they are kept in memory until it reads them
+func (b *Bus) SubscribeReliable(filter Filter) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscriber{
+		filter:   filter,
+		ch:       make(chan Event),
+		reliable: true,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	b.subscribers = append(b.subscribers, sub)
+	go b.pump(sub)
+
+	return sub.ch, func() { b.unsubscribe(sub) }
+}
+
+// pump passes backlog of reliable subscriber to its channel, channel is closed after unsubscribe
+func (b *Bus) pump(sub *subscriber) {
+	defer close(sub.ch)
+
+	for {
+		b.mu.Lock()
+		if len(sub.backlog) == 0 {
+			b.mu.Unlock()
+
+			select {
+			case <-sub.wake:
+				continue
+			case <-sub.done:
+				return
+			}
+		}
+
+		event := sub.backlog[0]
+		sub.backlog = sub.backlog[1:]
+		b.mu.Unlock()
+
+		select {
+		case sub.ch <- event:
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+func (b *Bus) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for index, item := range b.subscribers {
+		if item == sub {
+			b.subscribers = append(b.subscribers[:index], b.subscribers[index+1:]...)
+			if sub.reliable {
+				close(sub.done)
+			} else {
+				close(sub.ch)
+			}
+			break
+		}
+	}
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
@@ -0,0 +1,56 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestReliableSubscriberGetsAllEvents(t *testing.T) {
+	bus := NewBus()
+	live, unsubscribe := bus.SubscribeReliable(Filter{ProjectId: "1"})
+	defer unsubscribe()
+
+	const count = subscriberBuffer * 4
+	for index := 0; index < count; index++ {
+		bus.Publish(Event{Type: TypeBuildQueued, ProjectId: "1"})
+		bus.Publish(Event{Type: TypeBuildQueued, ProjectId: "2"})
+	}
+
+	var lastId uint
+	for index := 0; index < count; index++ {
+		event := <-live
+		if event.ProjectId != "1" || event.ID <= lastId {
+			t.Fatalf("unexpected event %+v after #%d", event, lastId)
+		}
+
+		lastId = event.ID
+	}
+
+	if bus.Dropped() != 0 {
+		t.Fatalf("reliable subscriber dropped %d events", bus.Dropped())
+	}
+}
+
+func TestLossySubscriberCountsDrops(t *testing.T) {
+	bus := NewBus()
+	_, unsubscribe := bus.Subscribe(Filter{})
+	defer unsubscribe()
+
+	for index := 0; index < subscriberBuffer+10; index++ {
+		bus.Publish(Event{Type: TypeBuildStep})
+	}
+
+	if dropped := bus.Dropped(); dropped != 10 {
+		t.Fatalf("expected 10 dropped events, got %d", dropped)
+	}
+}
+
+func TestUnsubscribeClosesReliableChannel(t *testing.T) {
+	bus := NewBus()
+	live, unsubscribe := bus.SubscribeReliable(Filter{})
+
+	bus.Publish(Event{Type: TypeBuildStep})
+	unsubscribe()
+
+	for range live {
+	}
+}
@@ -1,24 +1,35 @@
 package fsDriver
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/samber/lo"
-	"github.com/xanzy/go-gitlab"
 	"io"
+	"io/fs"
 	"log"
 	"mfe-worker/internal/configMap"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 const StorageSubDir = "images"
+const LatestDirName = "@latest"
+const MirrorsSubDir = "mirrors"
+
+// rebuildDirSeparator separates number of rebuild in dir name of revision
+const rebuildDirSeparator = "-"
 
 type FSDriver struct {
 	configMap  *configMap.ConfigMap
+	cacheMu    sync.RWMutex
 	ImagesPath string
+	CachePath  string
 }
 
 func NewFSDriver(configMap *configMap.ConfigMap) (*FSDriver, error) {
@@ -35,7 +46,12 @@ func NewFSDriver(configMap *configMap.ConfigMap) (*FSDriver, error) {
 		}
 	}
 
-	return &FSDriver{configMap: configMap, ImagesPath: imagesPath}, nil
+	cachePath := path.Join(configMap.StoragePath, CacheSubDir)
+	if err := os.MkdirAll(cachePath, 0755); err != nil {
+		return nil, errors.Join(fmt.Errorf(`failed on create dir: %s`, cachePath), err)
+	}
+
+	return &FSDriver{configMap: configMap, ImagesPath: imagesPath, CachePath: cachePath}, nil
 }
 
 func (d *FSDriver) IsDirExists(path string) bool {
@@ -75,6 +91,10 @@ func (d *FSDriver) CreateProjectBranchDir(projectId string, branch string) error
 	return d.CreateDir(d.GetProjectBranchPath(projectId, branch))
 }
 
+func (d *FSDriver) RemoveProjectBranchDir(projectId string, branch string) error {
+	return os.RemoveAll(d.GetProjectBranchPath(projectId, branch))
+}
+
 func (d *FSDriver) GetBranchRevisionPath(projectId string, branch string, revision string) string {
 	return filepath.Join(d.ImagesPath, projectId, branch, revision)
 }
@@ -87,6 +107,46 @@ func (d *FSDriver) CreateBranchRevisionDir(projectId string, branch string, revi
 	return d.CreateDir(d.GetBranchRevisionPath(projectId, branch, revision))
 }
 
+// RemoveBranchRevisionDirs removes dirs of all builds of revision, see RevisionDirName
+func (d *FSDriver) RemoveBranchRevisionDirs(projectId string, branch string, revision string) error {
+	entries, err := os.ReadDir(d.GetProjectBranchPath(projectId, branch))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == LatestDirName || RevisionOfDirName(entry.Name()) != revision {
+			continue
+		}
+
+		if err := os.RemoveAll(d.GetBranchRevisionPath(projectId, branch, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevisionDirName returns dir of revision build, every rebuild is published to own dir,
+// so files of immutable urls are never replaced. Revisions are commit ids, so they have no separator
+func RevisionDirName(revision string, rebuilds uint) string {
+	if rebuilds == 0 {
+		return revision
+	}
+
+	return fmt.Sprintf("%s%s%d", revision, rebuildDirSeparator, rebuilds)
+}
+
+// RevisionOfDirName returns revision of dir which was named by RevisionDirName
+func RevisionOfDirName(dirName string) string {
+	revision, _, _ := strings.Cut(dirName, rebuildDirSeparator)
+	return revision
+}
+
 func (d *FSDriver) CopyFile(source string, dest string) (err error) {
 	sourceFile, err := os.Open(source)
 	if err != nil {
@@ -158,17 +218,56 @@ func (d *FSDriver) CopyDir(source string, dest string) (err error) {
 }
 
 type PickedFile struct {
-	Path    string
-	WebPath string
+	Path      string
+	WebPath   string
+	Sha256    string
+	Integrity string
+}
+
+// HashFile returns hex encoded sha256 of file and its subresource integrity value
+func (d *FSDriver) HashFile(filePath string) (sha256Hex string, integrity string, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(file)
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return
+	}
+
+	sum := hash.Sum(nil)
+	return hex.EncodeToString(sum), "sha256-" + base64.StdEncoding.EncodeToString(sum), nil
 }
 
-func (d *FSDriver) PickFilesToWebStorage(project *configMap.Project, glBranch *gitlab.Branch, tmpPath string) (pickedFiles []PickedFile, err error) {
-	branchPath, err := filepath.Abs(d.GetProjectBranchPath(project.ProjectID, glBranch.Name))
+func (d *FSDriver) pickFile(project *configMap.Project, branch string, revision string, filePath string, destPath string) (PickedFile, error) {
+	sha256Hex, integrity, err := d.HashFile(destPath)
+	if err != nil {
+		return PickedFile{}, err
+	}
+
+	return PickedFile{
+		Path:      filePath,
+		WebPath:   d.GetRevisionWebPath(project.ProjectID, branch, revision) + filePath,
+		Sha256:    sha256Hex,
+		Integrity: integrity,
+	}, nil
+}
+
+func (d *FSDriver) PickFilesToWebStorage(project *configMap.Project, branch string, revision string, tmpPath string) (pickedFiles []PickedFile, err error) {
+	branchPath, err := filepath.Abs(d.GetProjectBranchPath(project.ProjectID, branch))
 	if err != nil {
 		return pickedFiles, err
 	}
 
-	revisionPath, err := filepath.Abs(d.GetBranchRevisionPath(project.ProjectID, glBranch.Name, glBranch.Commit.ID))
+	revisionPath, err := filepath.Abs(d.GetBranchRevisionPath(project.ProjectID, branch, revision))
 	if err != nil {
 		return pickedFiles, err
 	}
@@ -191,6 +290,29 @@ func (d *FSDriver) PickFilesToWebStorage(project *configMap.Project, glBranch *g
 			if err = d.CopyDir(filePath, destPath); err != nil {
 				return pickedFiles, err
 			}
+
+			err = filepath.WalkDir(destPath, func(walkPath string, entry fs.DirEntry, err error) error {
+				if err != nil || entry.IsDir() {
+					return err
+				}
+
+				relPath, err := filepath.Rel(revisionPath, walkPath)
+				if err != nil {
+					return err
+				}
+
+				pickedFile, err := d.pickFile(project, branch, revision, filepath.ToSlash(relPath), walkPath)
+				if err != nil {
+					return err
+				}
+
+				pickedFiles = append(pickedFiles, pickedFile)
+				return nil
+			})
+
+			if err != nil {
+				return pickedFiles, err
+			}
 		}
 
 		if !objInfo.IsDir() {
@@ -213,28 +335,41 @@ func (d *FSDriver) PickFilesToWebStorage(project *configMap.Project, glBranch *g
 			if err = d.CopyFile(filePath, destPath); err != nil {
 				return pickedFiles, err
 			}
+
+			pickedFile, err := d.pickFile(project, branch, revision, filePathWithoutTmpDir, destPath)
+			if err != nil {
+				return pickedFiles, err
+			}
+
+			pickedFiles = append(pickedFiles, pickedFile)
 		}
+	}
 
-		pickedFiles = append(
-			pickedFiles,
-			PickedFile{
-				Path: filePathWithoutTmpDir,
-				WebPath: fmt.Sprintf(
-					"%s/static/%s/%s/%s/%s",
-					d.configMap.HttpBaseUrl, project.ProjectID,
-					glBranch.Name, glBranch.Commit.ID, filePathWithoutTmpDir,
-				),
-			},
-		)
-	}
-
-	if _, err = os.Lstat(path.Join(branchPath, "@latest")); err == nil {
-		if err = os.Remove(path.Join(branchPath, "@latest")); err != nil {
+	if _, err = os.Lstat(path.Join(branchPath, LatestDirName)); err == nil {
+		if err = os.Remove(path.Join(branchPath, LatestDirName)); err != nil {
 			return
 		}
 	}
 
-	return pickedFiles, os.Symlink(revisionPath, path.Join(branchPath, "@latest"))
+	return pickedFiles, os.Symlink(revisionPath, path.Join(branchPath, LatestDirName))
+}
+
+// GetLatestRevision returns dir of revision build what @latest of branch points to or empty string, see RevisionDirName
+func (d *FSDriver) GetLatestRevision(projectId string, branch string) string {
+	target, err := os.Readlink(path.Join(d.GetProjectBranchPath(projectId, branch), LatestDirName))
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Base(target)
+}
+
+func (d *FSDriver) GetRevisionWebPath(projectId string, branch string, revision string) string {
+	return fmt.Sprintf("%s/static/%s/%s/%s/", d.configMap.HttpBaseUrl, projectId, branch, revision)
+}
+
+func (d *FSDriver) GetProjectMirrorPath(projectId string) string {
+	return path.Join(d.configMap.StoragePath, MirrorsSubDir, projectId+".git")
 }
 
 func (d *FSDriver) GetTmpPathForBuild(projectId string, branch string, revision string) string {
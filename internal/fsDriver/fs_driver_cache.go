@@ -0,0 +1,345 @@
+package fsDriver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mfe-worker/internal/configMap"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const CacheSubDir = "cache"
+
+const DefaultCacheMaxSizeMb = 2048
+
+// cacheSizeSuffix is suffix of file next to cache entry with its size, eviction reads it instead of walking entries
+const cacheSizeSuffix = ".size"
+
+var DefaultCacheKeyFiles = []string{"package-lock.json", "yarn.lock", "pnpm-lock.yaml"}
+
+type cacheEntry struct {
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+func (d *FSDriver) GetProjectCachePath(projectId string) string {
+	return filepath.Join(d.CachePath, projectId)
+}
+
+// CacheKey returns hash of lockfiles and cache paths of project or empty string when repo has no lockfiles
+func (d *FSDriver) CacheKey(project *configMap.Project, repoPath string) (string, error) {
+	keyFiles := project.Cache.KeyFiles
+	if len(keyFiles) == 0 {
+		keyFiles = DefaultCacheKeyFiles
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(strings.Join(project.Cache.Paths, "\n")))
+
+	found := false
+	for _, keyFile := range keyFiles {
+		file, err := os.Open(filepath.Join(repoPath, keyFile))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		hash.Write([]byte("\n" + keyFile + "\n"))
+		_, err = io.Copy(hash, file)
+		if closeErr := file.Close(); closeErr != nil {
+			log.Println(closeErr)
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		found = true
+	}
+
+	if !found {
+		return "", nil
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:32], nil
+}
+
+// RestoreCache copies cached paths of key into repo, false is returned when there is no cache of key
+func (d *FSDriver) RestoreCache(project *configMap.Project, key string, repoPath string) (bool, error) {
+	d.cacheMu.RLock()
+	defer d.cacheMu.RUnlock()
+
+	entryPath := filepath.Join(d.GetProjectCachePath(project.ProjectID), key)
+	if !d.IsDirExists(entryPath) {
+		return false, nil
+	}
+
+	for _, cachePath := range project.Cache.Paths {
+		source := filepath.Join(entryPath, cachePath)
+		if _, err := os.Lstat(source); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		dest := filepath.Join(repoPath, cachePath)
+		if err := os.RemoveAll(dest); err != nil {
+			return false, err
+		}
+
+		if err := copyTree(source, dest); err != nil {
+			return false, errors.Join(fmt.Errorf("failed on restore cache path %s", cachePath), err)
+		}
+	}
+
+	now := time.Now()
+	return true, os.Chtimes(entryPath, now, now)
+}
+
+// SaveCache stores cache paths of repo under key and evicts least recently used entries over size limit
+func (d *FSDriver) SaveCache(project *configMap.Project, key string, repoPath string) error {
+	projectCachePath := d.GetProjectCachePath(project.ProjectID)
+	entryPath := filepath.Join(projectCachePath, key)
+
+	if d.IsDirExists(entryPath) {
+		now := time.Now()
+		return os.Chtimes(entryPath, now, now)
+	}
+
+	if err := os.MkdirAll(projectCachePath, 0755); err != nil {
+		return err
+	}
+
+	// entry is prepared aside and renamed, so restore never sees half written cache
+	tmpPath, err := os.MkdirTemp(projectCachePath, ".tmp-"+key)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := os.RemoveAll(tmpPath); err != nil {
+			log.Printf("failed on remove tmp cache dir: %s", err)
+		}
+	}()
+
+	for _, cachePath := range project.Cache.Paths {
+		source := filepath.Join(repoPath, cachePath)
+		if _, err := os.Lstat(source); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err := copyTree(source, filepath.Join(tmpPath, cachePath)); err != nil {
+			return errors.Join(fmt.Errorf("failed on save cache path %s", cachePath), err)
+		}
+	}
+
+	size, err := dirSize(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+
+	if err := os.Rename(tmpPath, entryPath); err != nil {
+		if !d.IsDirExists(entryPath) {
+			return err
+		}
+
+		// entry was saved by concurrent build, it has own size file
+		return d.evictCache(project, key)
+	}
+
+	if err := writeCacheSize(entryPath, size); err != nil {
+		return err
+	}
+
+	return d.evictCache(project, key)
+}
+
+// evictCache removes least recently used entries of project until cache fits to size limit, entry of key is kept,
+// sizes of entries are read from their size files
+func (d *FSDriver) evictCache(project *configMap.Project, keepKey string) error {
+	maxSize := int64(project.Cache.MaxSizeMb) * 1024 * 1024
+	if maxSize <= 0 {
+		maxSize = DefaultCacheMaxSizeMb * 1024 * 1024
+	}
+
+	projectCachePath := d.GetProjectCachePath(project.ProjectID)
+	dirEntries, err := os.ReadDir(projectCachePath)
+	if err != nil {
+		return err
+	}
+
+	var entries []cacheEntry
+	var total int64
+
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || strings.HasPrefix(dirEntry.Name(), ".tmp-") {
+			continue
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil {
+			return err
+		}
+
+		entryPath := filepath.Join(projectCachePath, dirEntry.Name())
+		size, err := cacheSize(entryPath)
+		if err != nil {
+			return err
+		}
+
+		total += size
+		if dirEntry.Name() != keepKey {
+			entries = append(entries, cacheEntry{path: entryPath, size: size, lastUsed: info.ModTime()})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.Before(entries[j].lastUsed)
+	})
+
+	for _, entry := range entries {
+		if total <= maxSize {
+			break
+		}
+
+		if err := os.RemoveAll(entry.path); err != nil {
+			return err
+		}
+
+		if err := os.Remove(entry.path + cacheSizeSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		log.Printf("evicted cache entry %s", entry.path)
+		total -= entry.size
+	}
+
+	return nil
+}
+
+// cacheSize reads size of entry which was recorded by SaveCache, size of entry without record is computed and recorded once
+func cacheSize(entryPath string) (int64, error) {
+	content, err := os.ReadFile(entryPath + cacheSizeSuffix)
+	if err == nil {
+		if size, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64); err == nil {
+			return size, nil
+		}
+	}
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+
+	size, err := dirSize(entryPath)
+	if err != nil {
+		return 0, err
+	}
+
+	return size, writeCacheSize(entryPath, size)
+}
+
+func writeCacheSize(entryPath string, size int64) error {
+	return os.WriteFile(entryPath+cacheSizeSuffix, []byte(strconv.FormatInt(size, 10)), 0644)
+}
+
+func dirSize(path string) (size int64, err error) {
+	err = filepath.WalkDir(path, func(walkPath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+		return nil
+	})
+
+	return
+}
+
+// copyTree copies file or dir with modes and keeps symlinks as is, node_modules/.bin consists of them
+func copyTree(source string, dest string) error {
+	return filepath.WalkDir(source, func(walkPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(source, walkPath)
+		if err != nil {
+			return err
+		}
+
+		destPath := filepath.Join(dest, relPath)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(destPath, info.Mode().Perm()|0700)
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(walkPath)
+			if err != nil {
+				return err
+			}
+
+			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+				return err
+			}
+
+			return os.Symlink(target, destPath)
+		case entry.Type().IsRegular():
+			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+				return err
+			}
+
+			return copyRegularFile(walkPath, destPath, info.Mode().Perm())
+		default:
+			return nil
+		}
+	})
+}
+
+func copyRegularFile(source string, dest string, mode fs.FileMode) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+
+	defer func(sourceFile *os.File) {
+		if err := sourceFile.Close(); err != nil {
+			log.Println(err)
+		}
+	}(sourceFile)
+
+	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(destFile, sourceFile); err != nil {
+		_ = destFile.Close()
+		return err
+	}
+
+	return destFile.Close()
+}
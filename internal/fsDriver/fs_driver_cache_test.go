@@ -0,0 +1,195 @@
+package fsDriver
+
+import (
+	"bytes"
+	"mfe-worker/internal/configMap"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestFSDriver(t *testing.T) *FSDriver {
+	t.Helper()
+
+	driver, err := NewFSDriver(&configMap.ConfigMap{StoragePath: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return driver
+}
+
+func writeFile(t *testing.T, path string, content []byte, mode os.FileMode) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, content, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cacheProject(paths ...string) *configMap.Project {
+	return &configMap.Project{ProjectID: "1", Cache: configMap.Cache{Paths: paths}}
+}
+
+func TestCacheKey(t *testing.T) {
+	driver := newTestFSDriver(t)
+	project := cacheProject("node_modules")
+	repo := t.TempDir()
+
+	key := func(project *configMap.Project) string {
+		t.Helper()
+
+		value, err := driver.CacheKey(project, repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return value
+	}
+
+	if value := key(project); value != "" {
+		t.Fatalf("repo without lockfiles has key %s", value)
+	}
+
+	writeFile(t, filepath.Join(repo, "package-lock.json"), []byte(`{"lockfileVersion": 3}`), 0644)
+	first := key(project)
+	if len(first) != 32 || key(project) != first {
+		t.Fatalf("key is not stable: %s", first)
+	}
+
+	if key(cacheProject("node_modules", ".npm")) == first {
+		t.Fatal("key doesn't depend on cache paths")
+	}
+
+	writeFile(t, filepath.Join(repo, "package-lock.json"), []byte(`{"lockfileVersion": 2}`), 0644)
+	if key(project) == first {
+		t.Fatal("key doesn't depend on lockfile")
+	}
+
+	custom := cacheProject("node_modules")
+	custom.Cache.KeyFiles = []string{"bun.lockb"}
+	if value := key(custom); value != "" {
+		t.Fatalf("default lockfile is used with custom key files: %s", value)
+	}
+}
+
+func TestCacheRoundTrip(t *testing.T) {
+	driver := newTestFSDriver(t)
+	project := cacheProject("node_modules", ".missing")
+
+	repo := t.TempDir()
+	writeFile(t, filepath.Join(repo, "node_modules", "tool", "cli.js"), []byte("#!/usr/bin/env node"), 0755)
+	writeFile(t, filepath.Join(repo, "node_modules", "tool", "package.json"), []byte(`{"name": "tool"}`), 0644)
+	if err := os.MkdirAll(filepath.Join(repo, "node_modules", ".bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("../tool/cli.js", filepath.Join(repo, "node_modules", ".bin", "tool")); err != nil {
+		t.Fatal(err)
+	}
+
+	if restored, err := driver.RestoreCache(project, "key", t.TempDir()); err != nil || restored {
+		t.Fatalf("cache was restored before save: %v", err)
+	}
+
+	if err := driver.SaveCache(project, "key", repo); err != nil {
+		t.Fatal(err)
+	}
+
+	target := t.TempDir()
+	writeFile(t, filepath.Join(target, "node_modules", "stale.js"), []byte("stale"), 0644)
+
+	restored, err := driver.RestoreCache(project, "key", target)
+	if err != nil || !restored {
+		t.Fatalf("cache was not restored: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(target, "node_modules", ".bin", "tool"))
+	if err != nil || !bytes.Equal(content, []byte("#!/usr/bin/env node")) {
+		t.Fatalf("unexpected content of linked file %q: %v", content, err)
+	}
+
+	link, err := os.Readlink(filepath.Join(target, "node_modules", ".bin", "tool"))
+	if err != nil || link != "../tool/cli.js" {
+		t.Fatalf("symlink was not kept: %s %v", link, err)
+	}
+
+	info, err := os.Stat(filepath.Join(target, "node_modules", "tool", "cli.js"))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("mode was not kept: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(target, "node_modules", "stale.js")); !os.IsNotExist(err) {
+		t.Fatal("stale file of target was kept")
+	}
+
+	size, err := os.ReadFile(filepath.Join(driver.GetProjectCachePath("1"), "key"+cacheSizeSuffix))
+	if err != nil || len(size) == 0 {
+		t.Fatalf("size of entry was not recorded: %v", err)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	driver := newTestFSDriver(t)
+	project := cacheProject("node_modules")
+	project.Cache.MaxSizeMb = 2
+
+	projectCachePath := driver.GetProjectCachePath("1")
+	save := func(key string) {
+		t.Helper()
+
+		repo := t.TempDir()
+		writeFile(t, filepath.Join(repo, "node_modules", "blob"), make([]byte, 800*1024), 0644)
+		if err := driver.SaveCache(project, key, repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	touch := func(key string, at time.Time) {
+		t.Helper()
+
+		if err := os.Chtimes(filepath.Join(projectCachePath, key), at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exists := func(key string) bool {
+		return driver.IsDirExists(filepath.Join(projectCachePath, key))
+	}
+
+	save("a")
+	save("b")
+	touch("a", time.Now().Add(-2*time.Hour))
+	touch("b", time.Now().Add(-3*time.Hour))
+
+	// restore marks entry as recently used
+	if restored, err := driver.RestoreCache(project, "a", t.TempDir()); err != nil || !restored {
+		t.Fatalf("cache was not restored: %v", err)
+	}
+
+	save("c")
+
+	if !exists("a") || exists("b") || !exists("c") {
+		t.Fatalf("unexpected entries a=%v b=%v c=%v", exists("a"), exists("b"), exists("c"))
+	}
+
+	if _, err := os.Stat(filepath.Join(projectCachePath, "b"+cacheSizeSuffix)); !os.IsNotExist(err) {
+		t.Fatal("size file of evicted entry was kept")
+	}
+
+	// eviction trusts recorded size, so entries are not walked on every save
+	if err := os.WriteFile(filepath.Join(projectCachePath, "a"+cacheSizeSuffix), []byte("1073741824"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	save("d")
+
+	if exists("a") || !exists("d") {
+		t.Fatalf("recorded size was not used, a=%v d=%v", exists("a"), exists("d"))
+	}
+}
@@ -0,0 +1,50 @@
+package fsDriver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRevisionDirName(t *testing.T) {
+	revision := "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"
+
+	if dir := RevisionDirName(revision, 0); dir != revision {
+		t.Fatalf("first build has own dir %s", dir)
+	}
+
+	if dir := RevisionDirName(revision, 2); dir == revision || RevisionOfDirName(dir) != revision {
+		t.Fatalf("unexpected dir of rebuild %s", dir)
+	}
+}
+
+func TestRemoveBranchRevisionDirs(t *testing.T) {
+	driver := newTestFSDriver(t)
+	revision := "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"
+	other := "82b3d5ae55f7080f1e6022629cdb57bfae7cccc7"
+
+	for _, dir := range []string{RevisionDirName(revision, 0), RevisionDirName(revision, 1), other} {
+		writeFile(t, filepath.Join(driver.GetBranchRevisionPath("1", "main", dir), "app.js"), []byte("export {}"), 0644)
+	}
+
+	latestPath := filepath.Join(driver.GetProjectBranchPath("1", "main"), LatestDirName)
+	if err := os.Symlink(driver.GetBranchRevisionPath("1", "main", other), latestPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := driver.RemoveBranchRevisionDirs("1", "main", revision); err != nil {
+		t.Fatal(err)
+	}
+
+	if driver.HasBranchRevisionDir("1", "main", revision) || driver.HasBranchRevisionDir("1", "main", RevisionDirName(revision, 1)) {
+		t.Fatal("dir of revision build was kept")
+	}
+
+	if !driver.HasBranchRevisionDir("1", "main", other) || driver.GetLatestRevision("1", "main") != other {
+		t.Fatal("dir of other revision was removed")
+	}
+
+	if err := driver.RemoveBranchRevisionDirs("1", "removed", revision); err != nil {
+		t.Fatalf("missing branch dir is error: %s", err)
+	}
+}
@@ -0,0 +1,155 @@
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"log"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/fsDriver"
+	"sync"
+	"time"
+)
+
+const DefaultInterval = time.Hour
+
+const (
+	ReasonKeepLast = "keep_last"
+	ReasonMaxAge   = "max_age"
+)
+
+type Candidate struct {
+	ProjectId  string    `json:"project_id"`
+	Branch     string    `json:"branch"`
+	Revision   string    `json:"revision"`
+	RevisionId uint      `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	Reason     string    `json:"reason"`
+}
+
+type GC struct {
+	mu           sync.Mutex
+	fsDriver     *fsDriver.FSDriver
+	dbDriver     *dbDriver.DBDriver
+	configMap    *configMap.ConfigMap
+	gitlabClient *gitlab.Client
+}
+
+func (g *GC) StartWorker() {
+	interval := g.configMap.GcInterval.Duration
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for range ticker.C {
+			removed, err := g.Run()
+			if err != nil {
+				log.Printf("gc error: %s", err)
+			}
+
+			if len(removed) > 0 {
+				log.Printf("gc removed %d revisions", len(removed))
+			}
+		}
+	}()
+}
+
+// Plan returns revisions which are out of retention policy of their projects
+func (g *GC) Plan() (candidates []Candidate, err error) {
+	now := time.Now()
+
+	for _, project := range g.configMap.Projects {
+		retention := project.Retention
+		if retention.KeepLast <= 0 && retention.MaxAge.Duration <= 0 {
+			continue
+		}
+
+		branches, err := g.dbDriver.GetAllBranches(project.ProjectID)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("failed on get branches of project %s", project.ProjectID), err)
+		}
+
+		for _, branch := range branches {
+			latestRevision := g.fsDriver.GetLatestRevision(project.ProjectID, branch.Name)
+
+			// revisions are sorted from newest to oldest
+			for index, revision := range branch.Revisions {
+				if revision.Name == fsDriver.RevisionOfDirName(latestRevision) || isBuildActive(revision.Build) {
+					continue
+				}
+
+				reason := ""
+				if retention.KeepLast > 0 && index >= retention.KeepLast {
+					reason = ReasonKeepLast
+				} else if retention.MaxAge.Duration > 0 && revision.CreatedAt.Before(now.Add(-retention.MaxAge.Duration)) {
+					reason = ReasonMaxAge
+				}
+
+				if len(reason) == 0 {
+					continue
+				}
+
+				candidates = append(candidates, Candidate{
+					ProjectId:  project.ProjectID,
+					Branch:     branch.Name,
+					Revision:   revision.Name,
+					RevisionId: revision.ID,
+					CreatedAt:  revision.CreatedAt,
+					Reason:     reason,
+				})
+			}
+		}
+	}
+
+	return candidates, nil
+}
+
+// Run removes db rows and storage dirs of revisions from Plan, revision which build was queued after Plan is kept
+func (g *GC) Run() ([]Candidate, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	candidates, err := g.Plan()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []Candidate
+	for _, candidate := range candidates {
+		// status of build is checked again by delete, dir is removed only when rows are deleted
+		revision := &dbDriver.Revision{Model: dbDriver.Model{ID: candidate.RevisionId}}
+		err := g.dbDriver.DeleteRevisionWithBuild(revision)
+		if errors.Is(err, dbDriver.ErrBuildActive) {
+			continue
+		}
+
+		if err != nil {
+			return removed, errors.Join(fmt.Errorf("failed on delete revision %s", candidate.Revision), err)
+		}
+
+		if err := g.fsDriver.RemoveBranchRevisionDirs(candidate.ProjectId, candidate.Branch, candidate.Revision); err != nil {
+			return removed, errors.Join(fmt.Errorf("failed on remove revision dir %s", candidate.Revision), err)
+		}
+
+		removed = append(removed, candidate)
+	}
+
+	return removed, nil
+}
+
+func isBuildActive(build *dbDriver.Build) bool {
+	return build != nil && (build.Status == dbDriver.BuildStatusQueued || build.Status == dbDriver.BuildStatusInProgress)
+}
+
+func NewGC(configMap *configMap.ConfigMap, fsDriver *fsDriver.FSDriver, dbDriver *dbDriver.DBDriver, gitlabClient *gitlab.Client) *GC {
+	return &GC{
+		fsDriver:     fsDriver,
+		dbDriver:     dbDriver,
+		configMap:    configMap,
+		gitlabClient: gitlabClient,
+	}
+}
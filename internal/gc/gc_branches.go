@@ -0,0 +1,159 @@
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/samber/lo"
+	"github.com/xanzy/go-gitlab"
+	"gorm.io/gorm"
+	"log"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/dbDriver"
+	"time"
+)
+
+const (
+	DefaultBranchSyncInterval = time.Hour
+	DefaultBranchGracePeriod  = 24 * time.Hour
+)
+
+func (g *GC) StartBranchSyncWorker() {
+	interval := g.configMap.BranchSyncInterval.Duration
+	if interval <= 0 {
+		interval = DefaultBranchSyncInterval
+	}
+
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for range ticker.C {
+			for index := range g.configMap.Projects {
+				if err := g.SyncBranches(&g.configMap.Projects[index]); err != nil {
+					log.Printf("branch sync error: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// SyncBranches marks branches which are missing in gitlab and removes them when grace period is over
+func (g *GC) SyncBranches(project *configMap.Project) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	gitlabBranches, err := g.listGitlabBranches(project.ProjectID)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed on list gitlab branches of project %s", project.ProjectID), err)
+	}
+
+	branches, err := g.dbDriver.GetAllBranches(project.ProjectID)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed on get branches of project %s", project.ProjectID), err)
+	}
+
+	now := time.Now()
+
+	for index := range branches {
+		branch := &branches[index]
+		if branch.Tag {
+			continue
+		}
+
+		existsInGitlab := lo.Contains(gitlabBranches, branch.Name)
+
+		if existsInGitlab && branch.RemovedAt != nil {
+			if err := g.dbDriver.SetBranchRemovedAt(branch, nil); err != nil {
+				return err
+			}
+		}
+
+		if !existsInGitlab && branch.RemovedAt == nil {
+			log.Printf("branch %s of project %s was removed in gitlab", branch.Name, project.ProjectID)
+			if err := g.dbDriver.SetBranchRemovedAt(branch, &now); err != nil {
+				return err
+			}
+		}
+	}
+
+	return g.removeExpiredBranches(project, branches)
+}
+
+// MarkBranchRemoved is used on branch delete push event, branch is removed after grace period
+func (g *GC) MarkBranchRemoved(projectId string, branchName string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	branch, err := g.dbDriver.GetBranch(projectId, branchName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if branch.Tag || branch.RemovedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	return g.dbDriver.SetBranchRemovedAt(branch, &now)
+}
+
+func (g *GC) removeExpiredBranches(project *configMap.Project, branches []dbDriver.Branch) error {
+	gracePeriod := g.configMap.BranchGracePeriod.Duration
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultBranchGracePeriod
+	}
+
+	removedBefore := time.Now().Add(-gracePeriod)
+
+	for index := range branches {
+		branch := &branches[index]
+		if branch.RemovedAt == nil || branch.RemovedAt.After(removedBefore) {
+			continue
+		}
+
+		// branch could be pushed again or get new build since it was listed, delete checks it again in transaction,
+		// so dir is removed only when rows are deleted
+		err := g.dbDriver.DeleteExpiredBranch(branch, removedBefore)
+		if errors.Is(err, dbDriver.ErrBranchNotExpired) || errors.Is(err, dbDriver.ErrBuildActive) || errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return errors.Join(fmt.Errorf("failed on delete branch %s", branch.Name), err)
+		}
+
+		if err := g.fsDriver.RemoveProjectBranchDir(project.ProjectID, branch.Name); err != nil {
+			return errors.Join(fmt.Errorf("failed on remove dir of branch %s", branch.Name), err)
+		}
+
+		log.Printf("removed branch %s of project %s", branch.Name, project.ProjectID)
+	}
+
+	return nil
+}
+
+func (g *GC) listGitlabBranches(projectId string) (names []string, err error) {
+	options := &gitlab.ListBranchesOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1},
+	}
+
+	for {
+		branches, resp, err := g.gitlabClient.Branches.ListBranches(projectId, options)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, branch := range branches {
+			names = append(names, branch.Name)
+		}
+
+		if resp.NextPage == 0 {
+			return names, nil
+		}
+
+		options.Page = resp.NextPage
+	}
+}
@@ -0,0 +1,52 @@
+package http
+
+import (
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const bearerPrefix = "Bearer "
+
+// accessTokenParam passes token of streams, browser EventSource and WebSocket can't set Authorization header
+const accessTokenParam = "access_token"
+
+func (h *Server) requireScope(scope string) echo.MiddlewareFunc {
+	return h.authorize(scope, false)
+}
+
+// requireStreamScope also accepts token from query of request, it is used only for event and log streams
+func (h *Server) requireStreamScope(scope string) echo.MiddlewareFunc {
+	return h.authorize(scope, true)
+}
+
+func (h *Server) authorize(scope string, allowQuery bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := ""
+			if header := c.Request().Header.Get(echo.HeaderAuthorization); strings.HasPrefix(header, bearerPrefix) {
+				token = strings.TrimPrefix(header, bearerPrefix)
+			} else if allowQuery {
+				token = c.QueryParam(accessTokenParam)
+			}
+
+			// api is closed when no token is configured
+			apiToken := h.di.ConfigMap.FindApiToken(token)
+			if len(token) == 0 || apiToken == nil {
+				return c.JSON(http.StatusUnauthorized, Response{
+					Meta: ResponseMeta{ErrorCode: ErrorUnauthorized},
+				})
+			}
+
+			if !apiToken.HasScope(scope) {
+				log.Printf("api token `%s` has no scope `%s` for %s", apiToken.Name, scope, c.Request().URL.Path)
+				return c.JSON(http.StatusForbidden, Response{
+					Meta: ResponseMeta{ErrorCode: ErrorForbidden},
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
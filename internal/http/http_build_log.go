@@ -0,0 +1,112 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"log"
+	"mfe-worker/internal/dbDriver"
+	"net/http"
+	"strings"
+)
+
+func (h *Server) GetBuildLog(c echo.Context) error {
+	projectId := c.Param("projectId")
+	refName, tag := getRef(c)
+	revision := c.Param("revision")
+
+	build, err := h.di.DBDriver.FindBuild(projectId, refName, tag, revision)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorDataNotFound},
+		})
+	}
+
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+		})
+	}
+
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/event-stream") {
+		return h.streamBuildLog(c, build)
+	}
+
+	buildLogs, err := h.di.DBDriver.GetBuildLogs(build)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+		})
+	}
+
+	return c.JSON(http.StatusOK, Response{
+		Meta:    ResponseMeta{Total: len(buildLogs)},
+		Payload: buildLogs,
+	})
+}
+
+func (h *Server) streamBuildLog(c echo.Context, build *dbDriver.Build) error {
+	// subscribe before reading stored lines, so nothing is lost between them
+	live, unsubscribe := h.di.Builder.SubscribeLogs(build.ID)
+	defer unsubscribe()
+
+	buildLogs, err := h.di.DBDriver.GetBuildLogs(build)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+		})
+	}
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set(echo.HeaderCacheControl, "no-cache")
+	response.Header().Set(echo.HeaderConnection, "keep-alive")
+	response.WriteHeader(http.StatusOK)
+
+	var lastId uint
+	for _, buildLog := range buildLogs {
+		if err := writeSSE(response, "log", buildLog.ID, buildLog); err != nil {
+			return err
+		}
+		lastId = buildLog.ID
+	}
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case buildLog, ok := <-live:
+			if !ok {
+				return writeSSE(response, "end", lastId, nil)
+			}
+
+			if buildLog.ID <= lastId {
+				continue
+			}
+
+			if err := writeSSE(response, "log", buildLog.ID, buildLog); err != nil {
+				return err
+			}
+			lastId = buildLog.ID
+		}
+	}
+}
+
+func writeSSE(response *echo.Response, event string, id uint, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(response, "id: %d\nevent: %s\ndata: %s\n\n", id, event, payload); err != nil {
+		return err
+	}
+
+	response.Flush()
+	return nil
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+	"mfe-worker/internal/events"
+	"net/http"
+	"time"
+)
+
+const eventsKeepAliveInterval = 30 * time.Second
+
+func eventsFilter(c echo.Context) events.Filter {
+	return events.Filter{
+		ProjectId: c.QueryParam("project_id"),
+		Branch:    c.QueryParam("branch"),
+	}
+}
+
+// GetEvents streams build lifecycle events as server-sent events
+func (h *Server) GetEvents(c echo.Context) error {
+	live, unsubscribe := h.di.Events.Subscribe(eventsFilter(c))
+	defer unsubscribe()
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set(echo.HeaderCacheControl, "no-cache")
+	response.Header().Set(echo.HeaderConnection, "keep-alive")
+	response.WriteHeader(http.StatusOK)
+	response.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepAlive.C:
+			// comment line keeps idle connection open behind proxies
+			if _, err := fmt.Fprint(response, ": keep-alive\n\n"); err != nil {
+				return err
+			}
+			response.Flush()
+		case event := <-live:
+			if err := writeSSE(response, event.Type, event.ID, event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// GetEventsWebSocket sends the same events as GetEvents, one JSON message per event
+func (h *Server) GetEventsWebSocket(c echo.Context) error {
+	filter := eventsFilter(c)
+
+	server := websocket.Server{Handler: func(conn *websocket.Conn) {
+		defer conn.Close()
+
+		live, unsubscribe := h.di.Events.Subscribe(filter)
+		defer unsubscribe()
+
+		// client doesn't send anything, reading is used to notice closed connection
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			var message string
+			for websocket.Message.Receive(conn, &message) == nil {
+			}
+		}()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case event := <-live:
+				if err := websocket.JSON.Send(conn, event); err != nil {
+					return
+				}
+			}
+		}
+	}}
+
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+)
+
+func (h *Server) GetGCPlan(c echo.Context) error {
+	candidates, err := h.di.GC.Plan()
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+		})
+	}
+
+	return c.JSON(http.StatusOK, Response{
+		Meta:    ResponseMeta{Total: len(candidates)},
+		Payload: candidates,
+	})
+}
+
+func (h *Server) RunGC(c echo.Context) error {
+	removed, err := h.di.GC.Run()
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, Response{
+			Meta:    ResponseMeta{ErrorCode: ErrorServerSuck},
+			Payload: removed,
+		})
+	}
+
+	return c.JSON(http.StatusOK, Response{
+		Meta:    ResponseMeta{Total: len(removed)},
+		Payload: removed,
+	})
+}
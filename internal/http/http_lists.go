@@ -67,10 +67,10 @@ func (h *Server) GetBranches(c echo.Context) error {
 
 func (h *Server) GetRevisions(c echo.Context) error {
 	projectId := c.Param("projectId")
-	branchName := c.Param("branch")
+	refName, tag := getRef(c)
 	limit, offset := getPagination(c)
 
-	revisions, total, err := h.di.DBDriver.GetRevisions(projectId, branchName, dbDriver.Pagination{
+	revisions, total, err := h.di.DBDriver.GetRevisions(projectId, refName, tag, dbDriver.Pagination{
 		Limit:  limit,
 		Offset: offset,
 	})
@@ -95,11 +95,11 @@ func (h *Server) GetRevisions(c echo.Context) error {
 
 func (h *Server) GetBuilds(c echo.Context) error {
 	projectId := c.Param("projectId")
-	branchName := c.Param("branch")
+	refName, tag := getRef(c)
 	revision := c.Param("revision")
 	limit, offset := getPagination(c)
 
-	branch, err := h.di.DBDriver.GetBranch(projectId, branchName)
+	branch, err := h.di.DBDriver.GetRef(projectId, refName, tag)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return c.JSON(http.StatusNotFound, Response{
 			Meta: ResponseMeta{ErrorCode: ErrorDataNotFound},
@@ -0,0 +1,192 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"log"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/fsDriver"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const importMapContentType = "application/importmap+json"
+
+type ImportMap struct {
+	Imports   map[string]string `json:"imports"`
+	Integrity map[string]string `json:"integrity,omitempty"`
+}
+
+type Manifest struct {
+	Project   string    `json:"project"`
+	Name      string    `json:"name"`
+	Branch    string    `json:"branch"`
+	Revision  string    `json:"revision"`
+	BaseUrl   string    `json:"base_url"`
+	Entry     string    `json:"entry"`
+	Styles    []string  `json:"styles"`
+	Assets    []string  `json:"assets"`
+	ImportMap ImportMap `json:"import_map"`
+}
+
+func (h *Server) GetManifest(c echo.Context) error {
+	projectId := c.Param("projectId")
+	branchName, tag := getRef(c)
+	revisionName := c.Param("revision")
+
+	projectFromConfig := h.di.ConfigMap.FindProject(projectId)
+	if projectFromConfig == nil {
+		return c.JSON(http.StatusBadRequest, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorUnknownProject},
+		})
+	}
+
+	var build *dbDriver.Build
+	var revision *dbDriver.Revision
+	var err error
+
+	if len(revisionName) == 0 {
+		build, err = h.di.DBDriver.GetLatestReadyBuild(projectId, branchName, tag)
+		if err == nil {
+			revision, err = h.di.DBDriver.GetRevision(build.RevisionId)
+		}
+	} else {
+		revision, err = h.di.DBDriver.FindRefRevision(projectId, branchName, tag, revisionName)
+		if err == nil {
+			build, err = h.di.DBDriver.GetBuildWithFiles(revision.ID)
+		}
+	}
+
+	if err == nil && build.Status != dbDriver.BuildStatusReady {
+		err = gorm.ErrRecordNotFound
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorDataNotFound},
+		})
+	}
+
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+		})
+	}
+
+	manifest := h.buildManifest(projectFromConfig, branchName, revision, build)
+
+	if c.QueryParam("format") == "importmap" {
+		c.Response().Header().Set(echo.HeaderContentType, importMapContentType)
+		return c.JSON(http.StatusOK, manifest.ImportMap)
+	}
+
+	return c.JSON(http.StatusOK, Response{Payload: manifest})
+}
+
+func (h *Server) buildManifest(project *configMap.Project, branchName string, revision *dbDriver.Revision, build *dbDriver.Build) Manifest {
+	manifest := Manifest{
+		Project:  project.ProjectID,
+		Name:     project.ProjectName,
+		Branch:   branchName,
+		Revision: revision.Name,
+		BaseUrl:  h.di.FSDriver.GetRevisionWebPath(project.ProjectID, branchName, fsDriver.RevisionDirName(revision.Name, build.Rebuilds)),
+		Styles:   []string{},
+		Assets:   []string{},
+	}
+
+	integrity := map[string]string{}
+
+	for _, file := range build.Files {
+		if len(file.Integrity) > 0 {
+			integrity[file.WebPath] = file.Integrity
+		}
+
+		switch strings.ToLower(path.Ext(file.Path)) {
+		case ".js", ".mjs":
+			if file.Path == project.EntryFile || len(project.EntryFile) == 0 && len(manifest.Entry) == 0 {
+				manifest.Entry = file.WebPath
+				continue
+			}
+			manifest.Assets = append(manifest.Assets, file.WebPath)
+		case ".css":
+			manifest.Styles = append(manifest.Styles, file.WebPath)
+		default:
+			manifest.Assets = append(manifest.Assets, file.WebPath)
+		}
+	}
+
+	manifest.ImportMap = ImportMap{
+		Imports:   map[string]string{project.ProjectName + "/": manifest.BaseUrl},
+		Integrity: integrity,
+	}
+
+	if len(manifest.Entry) > 0 {
+		manifest.ImportMap.Imports[project.ProjectName] = manifest.Entry
+	}
+
+	return manifest
+}
+
+func (h *Server) GetImportMap(c echo.Context) error {
+	requestBranch := c.QueryParam("branch")
+
+	importMap := ImportMap{Imports: map[string]string{}, Integrity: map[string]string{}}
+	etagHash := sha256.New()
+
+	for index := range h.di.ConfigMap.Projects {
+		project := &h.di.ConfigMap.Projects[index]
+
+		branchName := requestBranch
+		build, err := h.di.DBDriver.GetLatestReadyBuild(project.ProjectID, branchName, false)
+		if errors.Is(err, gorm.ErrRecordNotFound) && len(project.DefaultBranch) > 0 && project.DefaultBranch != branchName {
+			branchName = project.DefaultBranch
+			build, err = h.di.DBDriver.GetLatestReadyBuild(project.ProjectID, branchName, false)
+		}
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+
+		var revision *dbDriver.Revision
+		if err == nil {
+			revision, err = h.di.DBDriver.GetRevision(build.RevisionId)
+		}
+
+		if err != nil {
+			log.Println(err)
+			return c.JSON(http.StatusInternalServerError, Response{
+				Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+			})
+		}
+
+		manifest := h.buildManifest(project, branchName, revision, build)
+		for key, value := range manifest.ImportMap.Imports {
+			importMap.Imports[key] = value
+		}
+
+		for key, value := range manifest.ImportMap.Integrity {
+			importMap.Integrity[key] = value
+		}
+
+		etagHash.Write([]byte(fmt.Sprintf("%s:%d:%d;", project.ProjectID, revision.ID, build.UpdatedAt.UnixNano())))
+	}
+
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(etagHash.Sum(nil))[:32])
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	c.Response().Header().Set("ETag", etag)
+
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, importMapContentType)
+	return c.JSON(http.StatusOK, importMap)
+}
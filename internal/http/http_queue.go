@@ -0,0 +1,127 @@
+package http
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"log"
+	"mfe-worker/internal/builder"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/queue"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type QueueJob struct {
+	ID         uint               `json:"id"`
+	ProjectId  string             `json:"project_id"`
+	Branch     string             `json:"branch"`
+	Commit     string             `json:"commit"`
+	Status     dbDriver.JobStatus `json:"status"`
+	Priority   bool               `json:"priority"`
+	Position   int                `json:"position,omitempty"`
+	EnqueuedAt time.Time          `json:"enqueued_at"`
+	StartedAt  *time.Time         `json:"started_at"`
+	FinishedAt *time.Time         `json:"finished_at"`
+}
+
+func newQueueJob(job *dbDriver.Job, entry *queue.Entry) QueueJob {
+	queueJob := QueueJob{
+		ID:         job.ID,
+		ProjectId:  job.ProjectId,
+		Branch:     job.Branch,
+		Commit:     job.Commit,
+		Status:     job.Status,
+		EnqueuedAt: job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+	}
+
+	if entry != nil {
+		queueJob.Priority = entry.Lane == queue.LanePriority
+		queueJob.Position = entry.Position
+	}
+
+	return queueJob
+}
+
+func (h *Server) GetQueue(c echo.Context) error {
+	entries := h.di.Queue.Entries()
+
+	jobs := make([]QueueJob, 0, len(entries))
+	for index := range entries {
+		jobs = append(jobs, newQueueJob(&entries[index].Job, &entries[index]))
+	}
+
+	return c.JSON(http.StatusOK, Response{
+		Meta:    ResponseMeta{Total: len(jobs)},
+		Payload: jobs,
+	})
+}
+
+func (h *Server) GetJob(c echo.Context) error {
+	jobId, err := strconv.ParseUint(c.Param("jobId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorDataNotFound},
+		})
+	}
+
+	// jobs in queue have fresher state than db rows
+	entries := h.di.Queue.Entries()
+	for index := range entries {
+		if entries[index].Job.ID == uint(jobId) {
+			return c.JSON(http.StatusOK, Response{Payload: newQueueJob(&entries[index].Job, &entries[index])})
+		}
+	}
+
+	job, err := h.di.DBDriver.GetJob(uint(jobId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorDataNotFound},
+		})
+	}
+
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+		})
+	}
+
+	return c.JSON(http.StatusOK, Response{Payload: newQueueJob(job, nil)})
+}
+
+func (h *Server) DeleteQueueJob(c echo.Context) error {
+	jobId, err := strconv.ParseUint(c.Param("jobId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorDataNotFound},
+		})
+	}
+
+	err = h.di.Builder.DropJob(uint(jobId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorDataNotFound},
+		})
+	}
+
+	if errors.Is(err, builder.ErrJobNotQueued) {
+		return c.JSON(http.StatusConflict, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorJobNotQueued},
+		})
+	}
+
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+		})
+	}
+
+	return c.JSON(http.StatusOK, Response{
+		Payload: map[string]string{"code": "REMOVED_FROM_QUEUE"},
+	})
+}
@@ -2,32 +2,20 @@ package http
 
 import (
 	"errors"
-	"fmt"
 	"github.com/labstack/echo/v4"
-	"github.com/samber/lo"
 	"github.com/xanzy/go-gitlab"
 	"gorm.io/gorm"
 	"log"
-	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/builder"
 	"mfe-worker/internal/dbDriver"
-	"mfe-worker/internal/fsDriver"
-	"mfe-worker/internal/shell"
 	"net/http"
-	"strings"
-	"sync"
 )
 
 func (h *Server) RequestBuild(c echo.Context) error {
 	requestBranch := c.Param("branch")
 	requestProjectId := c.Param("projectId")
 
-	projectFromConfig := lo.Reduce(h.di.ConfigMap.Projects, func(agg *configMap.Project, item configMap.Project, index int) *configMap.Project {
-		if item.ProjectID == requestProjectId {
-			return &item
-		}
-		return agg
-	}, nil)
-
+	projectFromConfig := h.di.ConfigMap.FindProject(requestProjectId)
 	if projectFromConfig == nil {
 		return c.JSON(http.StatusBadRequest, Response{
 			Meta:    ResponseMeta{ErrorCode: ErrorUnknownProject},
@@ -35,63 +23,124 @@ func (h *Server) RequestBuild(c echo.Context) error {
 		})
 	}
 
-	if len(projectFromConfig.Branches) != 0 {
-		hasBranch := lo.Filter(projectFromConfig.Branches, func(item string, index int) bool {
-			return item == requestBranch
+	if !projectFromConfig.IsBranchAllowed(requestBranch) {
+		return c.JSON(http.StatusBadRequest, Response{
+			Meta:    ResponseMeta{ErrorCode: ErrorBranchNotAllowed},
+			Payload: nil,
 		})
+	}
+
+	commitId := c.QueryParam("sha")
 
-		if len(hasBranch) == 0 {
-			return c.JSON(http.StatusBadRequest, Response{
-				Meta:    ResponseMeta{ErrorCode: ErrorBranchNotAllowed},
-				Payload: nil,
-			})
+	if len(commitId) == 0 {
+		gitlabBranch, _, err := h.di.GitlabClient.Branches.GetBranch(requestProjectId, requestBranch)
+		if err != nil {
+			return gitlabErrorResponse(c, err)
 		}
+
+		job, err := h.di.Builder.RequestBuild(projectFromConfig, requestBranch, gitlabBranch.Commit.ID)
+		return requestBuildResponse(c, job, err)
 	}
 
-	gitlabBranch, _, err := h.di.GitlabClient.Branches.GetBranch(requestProjectId, requestBranch)
+	gitlabCommit, _, err := h.di.GitlabClient.Commits.GetCommit(requestProjectId, commitId)
 	if err != nil {
-		log.Println(err)
-		return c.JSON(http.StatusInternalServerError, Response{
-			Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+		return gitlabErrorResponse(c, err)
+	}
+
+	inBranch, err := h.isCommitInBranch(requestProjectId, gitlabCommit.ID, requestBranch)
+	if err != nil {
+		return gitlabErrorResponse(c, err)
+	}
+
+	if !inBranch {
+		return c.JSON(http.StatusBadRequest, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorCommitNotInBranch},
 		})
 	}
 
-	latestGitlabCommit := gitlabBranch.Commit
+	// pinned commit could be older than queued head of branch, so it is built without superseding
+	job, err := h.di.Builder.RequestCommitBuild(projectFromConfig, requestBranch, gitlabCommit.ID)
+	return requestBuildResponse(c, job, err)
+}
 
-	branch, err := h.di.DBDriver.GetBranch(requestProjectId, requestBranch)
-	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-		log.Println(err)
-		return c.JSON(http.StatusInternalServerError, Response{
-			Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+func (h *Server) RequestTagBuild(c echo.Context) error {
+	requestTag := c.Param("tag")
+	requestProjectId := c.Param("projectId")
+
+	projectFromConfig := h.di.ConfigMap.FindProject(requestProjectId)
+	if projectFromConfig == nil {
+		return c.JSON(http.StatusBadRequest, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorUnknownProject},
 		})
 	}
 
-	if branch == nil {
-		branch, err = h.di.DBDriver.CreateBranch(&dbDriver.Branch{
-			Name:      requestBranch,
-			ProjectId: requestProjectId,
+	if !projectFromConfig.IsTagAllowed(requestTag) {
+		return c.JSON(http.StatusBadRequest, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorTagNotAllowed},
 		})
+	}
 
+	gitlabTag, _, err := h.di.GitlabClient.Tags.GetTag(requestProjectId, requestTag)
+	if err != nil {
+		return gitlabErrorResponse(c, err)
+	}
+
+	job, err := h.di.Builder.RequestTagBuild(projectFromConfig, requestTag, gitlabTag.Commit.ID)
+	return requestBuildResponse(c, job, err)
+}
+
+func (h *Server) isCommitInBranch(projectId string, commitId string, branch string) (bool, error) {
+	options := &gitlab.GetCommitRefsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1},
+		Type:        gitlab.String("branch"),
+	}
+
+	for {
+		refs, resp, err := h.di.GitlabClient.Commits.GetCommitRefs(projectId, commitId, options)
 		if err != nil {
-			log.Println(err)
-			return c.JSON(http.StatusInternalServerError, Response{
-				Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
-			})
+			return false, err
 		}
-	}
 
-	for _, revision := range branch.Revisions {
-		if revision.Name == latestGitlabCommit.ID {
-			return c.JSON(http.StatusConflict, Response{
-				Meta: ResponseMeta{ErrorCode: ErrorRevisionExists},
-			})
+		for _, ref := range refs {
+			if ref.Name == branch {
+				return true, nil
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return false, nil
 		}
+
+		options.Page = resp.NextPage
+	}
+}
+
+func gitlabErrorResponse(c echo.Context, err error) error {
+	var gitlabErr *gitlab.ErrorResponse
+	if errors.As(err, &gitlabErr) && gitlabErr.Response.StatusCode == http.StatusNotFound {
+		return c.JSON(http.StatusNotFound, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorDataNotFound},
+		})
 	}
 
-	revision, err := h.di.DBDriver.CreateRevision(&dbDriver.Revision{
-		Name:     latestGitlabCommit.ID,
-		BranchId: branch.ID,
+	log.Println(err)
+	return c.JSON(http.StatusInternalServerError, Response{
+		Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
 	})
+}
+
+func requestBuildResponse(c echo.Context, job *dbDriver.Job, err error) error {
+	if errors.Is(err, builder.ErrRevisionExists) {
+		return c.JSON(http.StatusConflict, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorRevisionExists},
+		})
+	}
+
+	if errors.Is(err, builder.ErrRefNameTaken) {
+		return c.JSON(http.StatusConflict, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorRefNameTaken},
+		})
+	}
 
 	if err != nil {
 		log.Println(err)
@@ -100,107 +149,127 @@ func (h *Server) RequestBuild(c echo.Context) error {
 		})
 	}
 
-	h.di.Queue.AddToQueue(func(wg *sync.WaitGroup) error {
-		defer wg.Done()
+	return c.JSON(http.StatusOK, Response{
+		Payload: addedToQueue(job),
+	})
+}
 
-		gitProject, _, err := h.di.GitlabClient.Projects.GetProject(
-			requestProjectId,
-			&gitlab.GetProjectOptions{},
-		)
+func addedToQueue(job *dbDriver.Job) map[string]interface{} {
+	return map[string]interface{}{
+		"code":     "ADDED_TO_QUEUE",
+		"job_id":   job.ID,
+		"revision": job.Commit,
+	}
+}
 
-		if err != nil {
-			return err
-		}
+func (h *Server) RebuildRevision(c echo.Context) error {
+	requestBranch := c.Param("branch")
+	requestProjectId := c.Param("projectId")
+	requestRevision := c.Param("revision")
+
+	projectFromConfig := h.di.ConfigMap.FindProject(requestProjectId)
+	if projectFromConfig == nil {
+		return c.JSON(http.StatusBadRequest, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorUnknownProject},
+		})
+	}
 
-		build, err := h.di.DBDriver.CreateBuild(&dbDriver.Build{
-			Status:     dbDriver.BuildStatusInProgress,
-			RevisionId: revision.ID,
+	if !projectFromConfig.IsBranchAllowed(requestBranch) {
+		return c.JSON(http.StatusBadRequest, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorBranchNotAllowed},
 		})
+	}
 
-		if err != nil {
-			return err
-		}
+	job, err := h.di.Builder.RebuildRevision(projectFromConfig, requestBranch, requestRevision)
+	return rebuildResponse(c, job, err)
+}
 
-		defer func(fsDriver *fsDriver.FSDriver, projectId string, branch string, revision string) {
-			err := fsDriver.RemoveTmpDirForBuild(projectId, branch, revision)
-			if err != nil {
-				log.Printf("failed on clear tmp dir: %s", err)
-			}
-		}(h.di.FSDriver, requestProjectId, requestBranch, revision.Name)
+// RebuildTagRevision is RebuildRevision of tag, tags are checked by their own allow-list
+func (h *Server) RebuildTagRevision(c echo.Context) error {
+	requestTag := c.Param("tag")
+	requestProjectId := c.Param("projectId")
+	requestRevision := c.Param("revision")
 
-		tmpDirName := h.di.FSDriver.GetTmpPathForBuild(requestProjectId, requestBranch, gitlabBranch.Commit.ID)
+	projectFromConfig := h.di.ConfigMap.FindProject(requestProjectId)
+	if projectFromConfig == nil {
+		return c.JSON(http.StatusBadRequest, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorUnknownProject},
+		})
+	}
 
-		if h.di.FSDriver.HasTmpDirForBuild(requestProjectId, requestProjectId, gitlabBranch.Commit.ID) {
-			return fmt.Errorf("tmp dir already exists, skip: %s", tmpDirName)
-		}
+	if !projectFromConfig.IsTagAllowed(requestTag) {
+		return c.JSON(http.StatusBadRequest, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorTagNotAllowed},
+		})
+	}
 
-		clonePath := fmt.Sprintf(
-			"%s://oauth2:%s@%s/%s/%s.git",
-			"http",
-			h.di.ConfigMap.GitlabToken,
-			strings.Split(h.di.ConfigMap.GitlabUrl, "://")[1],
-			gitProject.Namespace.FullPath,
-			gitProject.Name,
-		)
+	job, err := h.di.Builder.RebuildTagRevision(projectFromConfig, requestTag, requestRevision)
+	return rebuildResponse(c, job, err)
+}
 
-		cloneArgs := []string{"clone", "--single-branch", "--branch", requestBranch, clonePath, tmpDirName}
+func rebuildResponse(c echo.Context, job *dbDriver.Job, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorDataNotFound},
+		})
+	}
 
-		if _, err = shell.ExecShellCommand("git", cloneArgs, shell.ExecShellCommandArgs{}); err != nil {
-			return errors.Join(fmt.Errorf("failed on clone project (args: %x)", cloneArgs), err)
-		}
+	if errors.Is(err, builder.ErrBuildInProgress) {
+		return c.JSON(http.StatusConflict, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorBuildInProgress},
+		})
+	}
 
-		for _, cmd := range projectFromConfig.BuildCommands {
-			cmdSegments := strings.Split(cmd, " ")
-			cmdName := cmdSegments[0]
-			cmdArgs := lo.Slice(cmdSegments, 1, len(cmdSegments))
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+		})
+	}
 
-			if _, err = shell.ExecShellCommand(cmdName, cmdArgs, shell.ExecShellCommandArgs{Cwd: tmpDirName, Debug: true}); err != nil {
-				return errors.Join(fmt.Errorf("failed on exec build command from cfg: %s ", cmd), err)
-			}
-		}
+	return c.JSON(http.StatusOK, Response{
+		Payload: addedToQueue(job),
+	})
+}
 
-		projectExists := h.di.FSDriver.HasProjectDir(requestProjectId)
-		if !projectExists {
-			if err := h.di.FSDriver.CreateProjectDir(requestProjectId); err != nil {
-				return err
-			}
-		}
+func (h *Server) CancelBuild(c echo.Context) error {
+	requestRef, tag := getRef(c)
+	requestProjectId := c.Param("projectId")
+	requestRevision := c.Param("revision")
 
-		branchExists := h.di.FSDriver.HasProjectBranchDir(requestProjectId, requestBranch)
-		if !branchExists {
-			if err := h.di.FSDriver.CreateProjectBranchDir(requestProjectId, requestBranch); err != nil {
-				return err
-			}
-		}
+	projectFromConfig := h.di.ConfigMap.FindProject(requestProjectId)
+	if projectFromConfig == nil {
+		return c.JSON(http.StatusBadRequest, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorUnknownProject},
+		})
+	}
 
-		branchRevisionExists := h.di.FSDriver.HasBranchRevisionDir(requestProjectId, requestBranch, gitlabBranch.Commit.ID)
-		if !branchRevisionExists {
-			if err := h.di.FSDriver.CreateBranchRevisionDir(requestProjectId, requestBranch, gitlabBranch.Commit.ID); err != nil {
-				return err
-			}
-		}
+	cancel := h.di.Builder.CancelRevision
+	if tag {
+		cancel = h.di.Builder.CancelTagRevision
+	}
 
-		pickedFiles, err := h.di.FSDriver.PickFilesToWebStorage(projectFromConfig, gitlabBranch, tmpDirName)
-		if err != nil {
-			return err
-		}
+	err := cancel(projectFromConfig, requestRef, requestRevision)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorDataNotFound},
+		})
+	}
 
-		var buildFiles []dbDriver.BuildFiles
-		for _, file := range pickedFiles {
-			buildFiles = append(buildFiles, dbDriver.BuildFiles{
-				Path:    file.Path,
-				WebPath: file.WebPath,
-				BuildId: build.ID,
-			})
-		}
+	if errors.Is(err, builder.ErrNothingToCancel) {
+		return c.JSON(http.StatusConflict, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorNothingToCancel},
+		})
+	}
 
-		build.Files = buildFiles
-		build.Status = dbDriver.BuildStatusReady
-		_, err = h.di.DBDriver.UpdateBuild(build)
-		return err
-	})
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+		})
+	}
 
 	return c.JSON(http.StatusOK, Response{
-		Payload: map[string]string{"code": "ADDED_TO_QUEUE"},
+		Payload: map[string]string{"code": "CANCELLED"},
 	})
 }
@@ -0,0 +1,152 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"mfe-worker/internal/builder"
+	"mfe-worker/internal/dbDriver"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// call runs handler with route params given as name and value pairs, status of response is returned
+func call(handler echo.HandlerFunc, params ...string) int {
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	recorder := httptest.NewRecorder()
+
+	var names, values []string
+	for index := 0; index < len(params); index += 2 {
+		names = append(names, params[index])
+		values = append(values, params[index+1])
+	}
+
+	c := echo.New().NewContext(request, recorder)
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+
+	if err := handler(c); err != nil {
+		return http.StatusInternalServerError
+	}
+
+	return recorder.Code
+}
+
+func rebuild(server *Server, projectId, branch, revision string) int {
+	return call(server.RebuildRevision, "projectId", projectId, "branch", branch, "revision", revision)
+}
+
+func TestConcurrentRebuildIsQueuedOnce(t *testing.T) {
+	server := newWebhookServer(t, diaspora())
+	revision := "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"
+
+	replay(t, server, "Push Hook", "push_hook.json", testWebhookToken)
+
+	if status := rebuild(server, "15", "master", revision); status != http.StatusConflict {
+		t.Fatalf("queued build was rebuilt with status %d", status)
+	}
+
+	job := server.di.Queue.Entries()[0].Job
+	server.di.Queue.Remove(job.ID)
+
+	build, err := server.di.DBDriver.GetBuildByRevision(job.RevisionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build.Status = dbDriver.BuildStatusFailed
+	if _, err := server.di.DBDriver.UpdateBuild(build); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, 8)
+	for i := 0; i < cap(statuses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- rebuild(server, "15", "master", revision)
+		}()
+	}
+
+	wg.Wait()
+	close(statuses)
+
+	accepted := 0
+	for status := range statuses {
+		if status == http.StatusOK {
+			accepted++
+		}
+	}
+
+	if entries := server.di.Queue.Entries(); accepted != 1 || len(entries) != 1 {
+		t.Fatalf("rebuild was accepted %d times, queue %+v", accepted, entries)
+	}
+
+	// rebuild is published to new dir, files of previous build stay under their immutable urls
+	build, err = server.di.DBDriver.GetBuildByRevision(job.RevisionId)
+	if err != nil || build.Rebuilds != 1 {
+		t.Fatalf("unexpected rebuilds counter %+v %v", build, err)
+	}
+}
+
+func TestTagIsNotFoundByBranchRoutes(t *testing.T) {
+	server := newWebhookServer(t, diaspora())
+	revision := "82b3d5ae55f7080f1e6022629cdb57bfae7cccc7"
+
+	replay(t, server, "Tag Push Hook", "tag_push_hook.json", testWebhookToken)
+
+	for _, handler := range []echo.HandlerFunc{server.GetBuilds, server.GetBuildLog, server.CancelBuild} {
+		if status := call(handler, "projectId", "15", "branch", "v1.0.0", "revision", revision); status != http.StatusNotFound {
+			t.Fatalf("tag was found by branch route with status %d", status)
+		}
+	}
+
+	if status := call(server.GetBuildLog, "projectId", "15", "tag", "v1.0.0", "revision", revision); status != http.StatusOK {
+		t.Fatalf("log of tag build was not found, status %d", status)
+	}
+
+	if status := call(server.CancelBuild, "projectId", "15", "tag", "v1.0.0", "revision", revision); status != http.StatusOK {
+		t.Fatalf("tag build was not cancelled, status %d", status)
+	}
+
+	if entries := server.di.Queue.Entries(); len(entries) != 0 {
+		t.Fatalf("cancelled tag build is queued: %+v", entries)
+	}
+}
+
+func TestBranchCantTakeNameOfTag(t *testing.T) {
+	server := newWebhookServer(t, diaspora())
+	project := server.di.ConfigMap.FindProject("15")
+
+	replay(t, server, "Tag Push Hook", "tag_push_hook.json", testWebhookToken)
+
+	_, err := server.di.Builder.RequestBuild(project, "v1.0.0", "da1560886d4f094c3e6c9ef40349f7d38b5d27d7")
+	if !errors.Is(err, builder.ErrRefNameTaken) {
+		t.Fatalf("branch took name of tag: %v", err)
+	}
+}
+
+func TestJobOfUnknownProjectFailsBuild(t *testing.T) {
+	server := newWebhookServer(t, diaspora())
+
+	replay(t, server, "Push Hook", "push_hook.json", testWebhookToken)
+
+	job := server.di.Queue.Entries()[0].Job
+	server.di.ConfigMap.Projects = nil
+
+	if err := server.di.Builder.RunJob(context.Background(), &job); err == nil {
+		t.Fatal("job of unknown project was run")
+	}
+
+	build, err := server.di.DBDriver.GetBuildByRevision(job.RevisionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if build.Status != dbDriver.BuildStatusFailed || build.FailedStep != builder.StepPrepare {
+		t.Fatalf("build was left in status %d", build.Status)
+	}
+}
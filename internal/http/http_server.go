@@ -1,9 +1,12 @@
 package http
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"log"
+	"mfe-worker/internal/configMap"
 	"mfe-worker/internal/di"
 	"net/url"
 )
@@ -15,9 +18,14 @@ type Server struct {
 func (h *Server) SetupHttpHandlers() error {
 	e := echo.New()
 
+	corsOrigins := h.di.ConfigMap.CorsOrigins
+	if len(corsOrigins) == 0 {
+		corsOrigins = []string{"*"}
+	}
+
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
+		AllowOrigins: corsOrigins,
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
 	}))
 
 	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
@@ -25,16 +33,58 @@ func (h *Server) SetupHttpHandlers() error {
 	}))
 
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
-		Format: "${time_rfc3339} method=${method}, uri=${uri}, status=${status}\n",
+		Format:        "${time_rfc3339} method=${method}, uri=${custom}, status=${status}\n",
+		CustomTagFunc: loggedUri,
 	}))
 
-	e.Static("static", h.di.FSDriver.ImagesPath)
+	if len(h.di.ConfigMap.ApiTokens) == 0 {
+		log.Println("no api_tokens in config, all api requests are refused with 401")
+	}
+
+	readScope := h.requireScope(configMap.ScopeRead)
+	readStreamScope := h.requireStreamScope(configMap.ScopeRead)
+	buildScope := h.requireScope(configMap.ScopeBuild)
+	adminScope := h.requireScope(configMap.ScopeAdmin)
+
+	var staticMiddlewares []echo.MiddlewareFunc
+	if h.di.ConfigMap.StaticAuth {
+		staticMiddlewares = append(staticMiddlewares, readScope)
+	}
+	staticMiddlewares = append(staticMiddlewares, h.staticCacheHeaders)
+
+	staticHandler := echo.StaticDirectoryHandler(echo.MustSubFS(e.Filesystem, h.di.FSDriver.ImagesPath), false)
+	e.GET("/static*", staticHandler, staticMiddlewares...)
 
-	e.GET("/request-build/:projectId/:branch", h.RequestBuild)
-	e.GET("/projects", h.GetProjects)
-	e.GET("/branches/:projectId", h.GetBranches)
-	e.GET("/revisions/:projectId/:branch", h.GetRevisions)
-	e.GET("/builds/:projectId/:branch/:revision", h.GetBuilds)
+	e.GET("/request-build/:projectId/:branch", h.RequestBuild, buildScope)
+	e.GET("/request-build/:projectId/tags/:tag", h.RequestTagBuild, buildScope)
+	e.GET("/projects", h.GetProjects, readScope)
+	e.GET("/branches/:projectId", h.GetBranches, readScope)
+	e.GET("/revisions/:projectId/:branch", h.GetRevisions, readScope)
+	e.GET("/revisions/:projectId/tags/:tag", h.GetRevisions, readScope)
+	e.GET("/builds/:projectId/:branch/:revision", h.GetBuilds, readScope)
+	e.GET("/builds/:projectId/tags/:tag/:revision", h.GetBuilds, readScope)
+	e.GET("/builds/:projectId/:branch/:revision/log", h.GetBuildLog, readStreamScope)
+	e.GET("/builds/:projectId/tags/:tag/:revision/log", h.GetBuildLog, readStreamScope)
+	e.POST("/builds/:projectId/:branch/:revision/rebuild", h.RebuildRevision, buildScope)
+	e.POST("/builds/:projectId/tags/:tag/:revision/rebuild", h.RebuildTagRevision, buildScope)
+	e.POST("/builds/:projectId/:branch/:revision/cancel", h.CancelBuild, buildScope)
+	e.POST("/builds/:projectId/tags/:tag/:revision/cancel", h.CancelBuild, buildScope)
+	e.GET("/manifest/:projectId/:branch", h.GetManifest, readScope)
+	e.GET("/manifest/:projectId/:branch/:revision", h.GetManifest, readScope)
+	e.GET("/manifest/:projectId/tags/:tag", h.GetManifest, readScope)
+	e.GET("/manifest/:projectId/tags/:tag/:revision", h.GetManifest, readScope)
+	e.GET("/import-map", h.GetImportMap, readScope)
+
+	e.GET("/queue", h.GetQueue, readScope)
+	e.DELETE("/queue/:jobId", h.DeleteQueueJob, buildScope)
+	e.GET("/jobs/:jobId", h.GetJob, readScope)
+	e.GET("/events", h.GetEvents, readStreamScope)
+	e.GET("/events/ws", h.GetEventsWebSocket, readStreamScope)
+
+	e.GET("/gc/dry-run", h.GetGCPlan, adminScope)
+	e.POST("/gc/run", h.RunGC, adminScope)
+
+	e.POST("/webhooks/gitlab", h.GitlabWebhook)
 
 	u, err := url.Parse(h.di.ConfigMap.HttpBaseUrl)
 	if err != nil {
@@ -44,6 +94,18 @@ func (h *Server) SetupHttpHandlers() error {
 	return e.Start(fmt.Sprintf("%s", u.Host))
 }
 
+// loggedUri writes uri of request to access log with hidden access token of streams
+func loggedUri(c echo.Context, buf *bytes.Buffer) (int, error) {
+	requestUrl := *c.Request().URL
+	query := requestUrl.Query()
+	if query.Has(accessTokenParam) {
+		query.Set(accessTokenParam, "***")
+		requestUrl.RawQuery = query.Encode()
+	}
+
+	return buf.WriteString(requestUrl.RequestURI())
+}
+
 func NewHttpServer(di *di.Container) (*Server, error) {
 	return &Server{
 		di: di,
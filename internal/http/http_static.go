@@ -0,0 +1,96 @@
+package http
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+	"mfe-worker/internal/fsDriver"
+	"net/http"
+	"strings"
+)
+
+const (
+	latestCacheControl   = "public, max-age=60"
+	revisionCacheControl = "public, max-age=31536000, immutable"
+)
+
+// staticCacheHeaders marks revision scoped files as immutable and revalidates @latest files by ETag
+func (h *Server) staticCacheHeaders(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		projectId, latestBranch, ok := h.splitStaticPath(strings.Trim(c.Param("*"), "/"))
+		if !ok {
+			return next(c)
+		}
+
+		if len(latestBranch) == 0 {
+			setCacheHeadersOnSuccess(c, map[string]string{echo.HeaderCacheControl: revisionCacheControl})
+			return next(c)
+		}
+
+		latestRevision := h.di.FSDriver.GetLatestRevision(projectId, latestBranch)
+		if len(latestRevision) == 0 {
+			return next(c)
+		}
+
+		etag := fmt.Sprintf(`"%s"`, latestRevision)
+		headers := map[string]string{echo.HeaderCacheControl: latestCacheControl, "ETag": etag}
+
+		if c.Request().Header.Get("If-None-Match") == etag {
+			for name, value := range headers {
+				c.Response().Header().Set(name, value)
+			}
+
+			return c.NoContent(http.StatusNotModified)
+		}
+
+		setCacheHeadersOnSuccess(c, headers)
+		return next(c)
+	}
+}
+
+// splitStaticPath parses <project>/<branch>/<revision or @latest>/<file> path, project id and branch could contain slashes,
+// so project is found by configured ids and branch is everything between project and @latest,
+// latestBranch is empty for revision scoped path
+func (h *Server) splitStaticPath(staticPath string) (projectId string, latestBranch string, ok bool) {
+	segments := strings.Split(staticPath, "/")
+	matched := 0
+
+	for _, project := range h.di.ConfigMap.Projects {
+		projectSegments := strings.Split(project.ProjectID, "/")
+
+		// branch, revision and file follow project, the longest matching id wins when one id is prefix of other
+		if len(projectSegments) <= matched || len(segments) < len(projectSegments)+3 ||
+			strings.Join(segments[:len(projectSegments)], "/") != project.ProjectID {
+			continue
+		}
+
+		matched = len(projectSegments)
+		projectId, latestBranch, ok = project.ProjectID, "", false
+
+		rest := segments[len(projectSegments):]
+		switch latestIndex := lo.IndexOf(rest[:len(rest)-1], fsDriver.LatestDirName); {
+		case latestIndex == -1:
+			ok = true
+		case latestIndex > 0:
+			latestBranch, ok = strings.Join(rest[:latestIndex], "/"), true
+		}
+	}
+
+	return projectId, latestBranch, ok
+}
+
+// setCacheHeadersOnSuccess sets headers right before response is written and only for 2xx status,
+// so missing file is not cached by browsers and CDN as immutable
+func setCacheHeadersOnSuccess(c echo.Context, headers map[string]string) {
+	response := c.Response()
+
+	response.Before(func() {
+		if response.Status < http.StatusOK || response.Status >= http.StatusMultipleChoices {
+			return
+		}
+
+		for name, value := range headers {
+			response.Header().Set(name, value)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/di"
+	"mfe-worker/internal/fsDriver"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+const testStaticRevision = "0123456789abcdef0123456789abcdef01234567"
+
+func newStaticServer(t *testing.T) *echo.Echo {
+	t.Helper()
+
+	config := &configMap.ConfigMap{
+		StoragePath: t.TempDir(),
+		HttpBaseUrl: "http://mfe.local",
+		Projects:    []configMap.Project{{ProjectID: "app"}, {ProjectID: "group/app"}},
+	}
+
+	fs, err := fsDriver.NewFSDriver(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range [][2]string{{"app", "release/1.2"}, {"group/app", "main"}} {
+		revisionPath := fs.GetBranchRevisionPath(ref[0], ref[1], testStaticRevision)
+		if err := os.MkdirAll(revisionPath, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(revisionPath, "app.js"), []byte("export {}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Symlink(revisionPath, filepath.Join(fs.GetProjectBranchPath(ref[0], ref[1]), fsDriver.LatestDirName)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := &Server{di: &di.Container{ConfigMap: config, FSDriver: fs}}
+
+	e := echo.New()
+	e.GET("/static*", echo.StaticDirectoryHandler(echo.MustSubFS(e.Filesystem, fs.ImagesPath), false), server.staticCacheHeaders)
+	return e
+}
+
+func TestStaticCacheHeaders(t *testing.T) {
+	e := newStaticServer(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		ifNoneMatch  string
+		status       int
+		cacheControl string
+		etag         string
+	}{
+		{name: "revision of branch with slash", path: "/static/app/release/1.2/" + testStaticRevision + "/app.js", status: http.StatusOK, cacheControl: revisionCacheControl},
+		{name: "latest of branch with slash", path: "/static/app/release/1.2/@latest/app.js", status: http.StatusOK, cacheControl: latestCacheControl, etag: `"` + testStaticRevision + `"`},
+		{name: "latest is revalidated", path: "/static/app/release/1.2/@latest/app.js", ifNoneMatch: `"` + testStaticRevision + `"`, status: http.StatusNotModified, cacheControl: latestCacheControl, etag: `"` + testStaticRevision + `"`},
+		{name: "latest of project with slash", path: "/static/group/app/main/@latest/app.js", status: http.StatusOK, cacheControl: latestCacheControl, etag: `"` + testStaticRevision + `"`},
+		{name: "missing revision file", path: "/static/app/release/1.2/" + testStaticRevision + "/missing.js", status: http.StatusNotFound},
+		{name: "missing latest file", path: "/static/app/release/1.2/@latest/missing.js", status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if len(test.ifNoneMatch) > 0 {
+				request.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+
+			recorder := httptest.NewRecorder()
+			e.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("unexpected status %d", recorder.Code)
+			}
+
+			if cacheControl := recorder.Header().Get(echo.HeaderCacheControl); cacheControl != test.cacheControl {
+				t.Fatalf("unexpected Cache-Control %q", cacheControl)
+			}
+
+			if etag := recorder.Header().Get("ETag"); etag != test.etag {
+				t.Fatalf("unexpected ETag %q", etag)
+			}
+		})
+	}
+}
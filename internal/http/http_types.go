@@ -1,12 +1,24 @@
 package http
 
 const (
-	ErrorServerSuck       = "SERVER_WAS_SUCK"
-	ErrorUnknownProject   = "UNKNOWN_PROJECT_ID"
-	ErrorBranchNotChanged = "BRANCH_NOT_CHANGED"
-	ErrorBranchNotAllowed = "BRANCH_NOT_ALLOWED"
-	ErrorRevisionExists   = "REVISION_ALREADY_EXISTS"
-	ErrorDataNotFound     = "DATA_NOT_FOUND"
+	ErrorServerSuck        = "SERVER_WAS_SUCK"
+	ErrorUnknownProject    = "UNKNOWN_PROJECT_ID"
+	ErrorBranchNotChanged  = "BRANCH_NOT_CHANGED"
+	ErrorBranchNotAllowed  = "BRANCH_NOT_ALLOWED"
+	ErrorTagNotAllowed     = "TAG_NOT_ALLOWED"
+	ErrorCommitNotInBranch = "COMMIT_NOT_IN_BRANCH"
+	ErrorRevisionExists    = "REVISION_ALREADY_EXISTS"
+	ErrorRefNameTaken      = "REF_NAME_TAKEN"
+	ErrorDataNotFound      = "DATA_NOT_FOUND"
+	ErrorBuildInProgress   = "BUILD_IN_PROGRESS"
+	ErrorNothingToCancel   = "NOTHING_TO_CANCEL"
+	ErrorJobNotQueued      = "JOB_NOT_QUEUED"
+
+	ErrorUnauthorized = "UNAUTHORIZED"
+	ErrorForbidden    = "FORBIDDEN"
+
+	ErrorBadWebhookPayload   = "BAD_WEBHOOK_PAYLOAD"
+	ErrorInvalidWebhookToken = "INVALID_WEBHOOK_TOKEN"
 )
 
 type ResponseMeta struct {
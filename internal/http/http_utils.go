@@ -21,3 +21,12 @@ func getPagination(c echo.Context) (limit int, offset int) {
 
 	return
 }
+
+// getRef returns name of branch or tag of route, tag routes have :tag param instead of :branch
+func getRef(c echo.Context) (name string, tag bool) {
+	if name = c.Param("tag"); len(name) > 0 {
+		return name, true
+	}
+
+	return c.Param("branch"), false
+}
@@ -0,0 +1,131 @@
+package http
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/samber/lo"
+	"github.com/xanzy/go-gitlab"
+	"io"
+	"log"
+	"mfe-worker/internal/builder"
+	"mfe-worker/internal/dbDriver"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+type webhookPush struct {
+	Tag               bool
+	Removed           bool
+	ProjectID         int
+	PathWithNamespace string
+	Ref               string
+	CheckoutSHA       string
+}
+
+// GitlabWebhook answers 200 to every push which was refused on purpose, code of refusal is in body,
+// because gitlab disables hook after repeated 4xx responses
+func (h *Server) GitlabWebhook(c echo.Context) error {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return webhookRefused(c, ErrorBadWebhookPayload)
+	}
+
+	event, err := gitlab.ParseWebhook(gitlab.HookEventType(c.Request()), body)
+	if err != nil {
+		log.Printf("failed on parse gitlab webhook: %s", err)
+		return webhookRefused(c, ErrorBadWebhookPayload)
+	}
+
+	var push webhookPush
+
+	switch e := event.(type) {
+	case *gitlab.PushEvent:
+		push = webhookPush{
+			Removed:           e.After == zeroSHA,
+			ProjectID:         e.ProjectID,
+			PathWithNamespace: e.Project.PathWithNamespace,
+			Ref:               strings.TrimPrefix(e.Ref, "refs/heads/"),
+			CheckoutSHA:       e.CheckoutSHA,
+		}
+	case *gitlab.TagEvent:
+		push = webhookPush{
+			Tag:               true,
+			ProjectID:         e.ProjectID,
+			PathWithNamespace: e.Project.PathWithNamespace,
+			Ref:               strings.TrimPrefix(e.Ref, "refs/tags/"),
+			CheckoutSHA:       e.CheckoutSHA,
+		}
+	default:
+		return c.JSON(http.StatusOK, Response{
+			Payload: map[string]string{"code": "IGNORED"},
+		})
+	}
+
+	projectFromConfig := h.di.ConfigMap.FindProject(strconv.Itoa(push.ProjectID))
+	if projectFromConfig == nil {
+		projectFromConfig = h.di.ConfigMap.FindProject(push.PathWithNamespace)
+	}
+
+	if projectFromConfig == nil {
+		return webhookRefused(c, ErrorUnknownProject)
+	}
+
+	requestToken := c.Request().Header.Get("X-Gitlab-Token")
+	if len(projectFromConfig.WebhookToken) == 0 ||
+		subtle.ConstantTimeCompare([]byte(requestToken), []byte(projectFromConfig.WebhookToken)) != 1 {
+		return c.JSON(http.StatusUnauthorized, Response{
+			Meta: ResponseMeta{ErrorCode: ErrorInvalidWebhookToken},
+		})
+	}
+
+	if push.Removed && !push.Tag {
+		if err := h.di.GC.MarkBranchRemoved(projectFromConfig.ProjectID, push.Ref); err != nil {
+			log.Println(err)
+			return c.JSON(http.StatusInternalServerError, Response{
+				Meta: ResponseMeta{ErrorCode: ErrorServerSuck},
+			})
+		}
+
+		return c.JSON(http.StatusOK, Response{
+			Payload: map[string]string{"code": "BRANCH_REMOVED"},
+		})
+	}
+
+	isRefAllowed := lo.Ternary(push.Tag, projectFromConfig.IsTagAllowed(push.Ref), projectFromConfig.IsBranchAllowed(push.Ref))
+
+	// checkout_sha is empty when ref was removed, nothing to build
+	if len(push.CheckoutSHA) == 0 || !isRefAllowed {
+		return c.JSON(http.StatusOK, Response{
+			Payload: map[string]string{"code": "IGNORED"},
+		})
+	}
+
+	var job *dbDriver.Job
+	if push.Tag {
+		job, err = h.di.Builder.RequestTagBuild(projectFromConfig, push.Ref, push.CheckoutSHA)
+	} else {
+		job, err = h.di.Builder.RequestBuild(projectFromConfig, push.Ref, push.CheckoutSHA)
+	}
+
+	// gitlab redelivers hooks, so revision which was built already is not an error for it
+	if errors.Is(err, builder.ErrRevisionExists) {
+		return webhookRefused(c, ErrorRevisionExists)
+	}
+
+	// gitlab would retry push which can never be built, so name taken by other kind of ref is not an error for it
+	if errors.Is(err, builder.ErrRefNameTaken) {
+		return webhookRefused(c, ErrorRefNameTaken)
+	}
+
+	return requestBuildResponse(c, job, err)
+}
+
+func webhookRefused(c echo.Context, errorCode string) error {
+	return c.JSON(http.StatusOK, Response{
+		Meta: ResponseMeta{ErrorCode: errorCode},
+	})
+}
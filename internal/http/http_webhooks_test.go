@@ -0,0 +1,236 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"mfe-worker/internal/builder"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/di"
+	"mfe-worker/internal/events"
+	"mfe-worker/internal/fsDriver"
+	"mfe-worker/internal/gc"
+	"mfe-worker/internal/queue"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testWebhookToken = "hook-secret"
+
+type webhookResponse struct {
+	Meta    ResponseMeta   `json:"_meta"`
+	Payload map[string]any `json:"payload"`
+	Status  int            `json:"-"`
+}
+
+func newWebhookServer(t *testing.T, project configMap.Project) *Server {
+	t.Helper()
+
+	config := &configMap.ConfigMap{
+		DBPath:      filepath.Join(t.TempDir(), "db.sqlite"),
+		StoragePath: t.TempDir(),
+		Projects:    []configMap.Project{project},
+	}
+
+	fs, err := fsDriver.NewFSDriver(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := dbDriver.NewDBDriver(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := events.NewBus()
+	jobs := queue.NewQueue(config, db, bus)
+	builderInstance := builder.NewBuilder(config, jobs, fs, db, nil, bus)
+	gcInstance := gc.NewGC(config, fs, db, nil)
+
+	server, err := NewHttpServer(di.NewDIContainer(config, jobs, builderInstance, gcInstance, bus, fs, db, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server
+}
+
+// replay sends recorded gitlab payload from testdata as gitlab does
+func replay(t *testing.T, server *Server, eventType string, payloadFile string, token string) webhookResponse {
+	t.Helper()
+
+	payload, err := os.ReadFile(filepath.Join("testdata", payloadFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/webhooks/gitlab", bytes.NewReader(payload))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set("X-Gitlab-Event", eventType)
+	request.Header.Set("X-Gitlab-Token", token)
+
+	recorder := httptest.NewRecorder()
+	if err := server.GitlabWebhook(echo.New().NewContext(request, recorder)); err != nil {
+		t.Fatal(err)
+	}
+
+	var response webhookResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unexpected body %s", recorder.Body)
+	}
+
+	response.Status = recorder.Code
+	return response
+}
+
+func diaspora() configMap.Project {
+	return configMap.Project{ProjectID: "15", ProjectName: "diaspora", WebhookToken: testWebhookToken}
+}
+
+func TestWebhookPushIsQueued(t *testing.T) {
+	server := newWebhookServer(t, diaspora())
+
+	response := replay(t, server, "Push Hook", "push_hook.json", testWebhookToken)
+	if response.Status != http.StatusOK || response.Payload["code"] != "ADDED_TO_QUEUE" ||
+		response.Payload["revision"] != "da1560886d4f094c3e6c9ef40349f7d38b5d27d7" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	entries := server.di.Queue.Entries()
+	if len(entries) != 1 || entries[0].Job.Branch != "master" {
+		t.Fatalf("unexpected queue %+v", entries)
+	}
+}
+
+func TestWebhookPushKeepsPinnedBuilds(t *testing.T) {
+	server := newWebhookServer(t, diaspora())
+	project := server.di.ConfigMap.FindProject("15")
+
+	if _, err := server.di.Builder.RequestCommitBuild(project, "master", "b6568db1bc1dcd7f8b4d5a946b0b91f9dacd7327"); err != nil {
+		t.Fatal(err)
+	}
+
+	replay(t, server, "Push Hook", "push_hook.json", testWebhookToken)
+
+	entries := server.di.Queue.Entries()
+	if len(entries) != 2 || !entries[0].Job.Pinned || entries[1].Job.Pinned {
+		t.Fatalf("pinned build was superseded by push: %+v", entries)
+	}
+}
+
+func TestWebhookRedeliveryIsNotClientError(t *testing.T) {
+	server := newWebhookServer(t, diaspora())
+
+	replay(t, server, "Push Hook", "push_hook.json", testWebhookToken)
+
+	response := replay(t, server, "Push Hook", "push_hook.json", testWebhookToken)
+	if response.Status != http.StatusOK || response.Meta.ErrorCode != ErrorRevisionExists {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	if entries := server.di.Queue.Entries(); len(entries) != 1 {
+		t.Fatalf("revision was queued twice: %+v", entries)
+	}
+}
+
+func TestWebhookProjectIsFoundByPath(t *testing.T) {
+	project := diaspora()
+	project.ProjectID = "mike/diaspora"
+	server := newWebhookServer(t, project)
+
+	response := replay(t, server, "Push Hook", "push_hook.json", testWebhookToken)
+	if response.Status != http.StatusOK || response.Payload["code"] != "ADDED_TO_QUEUE" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
+
+func TestWebhookUnknownProjectIsNotClientError(t *testing.T) {
+	project := diaspora()
+	project.ProjectID = "16"
+	server := newWebhookServer(t, project)
+
+	response := replay(t, server, "Push Hook", "push_hook.json", testWebhookToken)
+	if response.Status != http.StatusOK || response.Meta.ErrorCode != ErrorUnknownProject {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
+
+func TestWebhookInvalidToken(t *testing.T) {
+	server := newWebhookServer(t, diaspora())
+
+	response := replay(t, server, "Push Hook", "push_hook.json", "wrong")
+	if response.Status != http.StatusUnauthorized || response.Meta.ErrorCode != ErrorInvalidWebhookToken {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	if entries := server.di.Queue.Entries(); len(entries) != 0 {
+		t.Fatalf("push with wrong token was queued: %+v", entries)
+	}
+}
+
+func TestWebhookTagPushIsQueued(t *testing.T) {
+	server := newWebhookServer(t, diaspora())
+
+	response := replay(t, server, "Tag Push Hook", "tag_push_hook.json", testWebhookToken)
+	if response.Status != http.StatusOK || response.Payload["code"] != "ADDED_TO_QUEUE" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	tag, err := server.di.DBDriver.GetRef("15", "v1.0.0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !tag.Tag {
+		t.Fatal("tag was stored as branch")
+	}
+}
+
+func TestWebhookNotAllowedBranchIsIgnored(t *testing.T) {
+	project := diaspora()
+	project.Branches = []string{"main"}
+	server := newWebhookServer(t, project)
+
+	response := replay(t, server, "Push Hook", "push_hook.json", testWebhookToken)
+	if response.Status != http.StatusOK || response.Payload["code"] != "IGNORED" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
+
+func TestWebhookBranchRemoval(t *testing.T) {
+	server := newWebhookServer(t, diaspora())
+
+	replay(t, server, "Push Hook", "push_hook.json", testWebhookToken)
+
+	response := replay(t, server, "Push Hook", "push_hook_branch_removed.json", testWebhookToken)
+	if response.Status != http.StatusOK || response.Payload["code"] != "BRANCH_REMOVED" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	branch, err := server.di.DBDriver.GetBranch("15", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if branch.RemovedAt == nil {
+		t.Fatal("branch was not marked as removed")
+	}
+}
+
+func TestWebhookOtherEventsAreIgnored(t *testing.T) {
+	server := newWebhookServer(t, diaspora())
+
+	response := replay(t, server, "Issue Hook", "issue_hook.json", testWebhookToken)
+	if response.Status != http.StatusOK || response.Payload["code"] != "IGNORED" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	response = replay(t, server, "Unknown Hook", "issue_hook.json", testWebhookToken)
+	if response.Status != http.StatusOK || response.Meta.ErrorCode != ErrorBadWebhookPayload {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
@@ -0,0 +1,324 @@
+package notifier
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/samber/lo"
+	"github.com/xanzy/go-gitlab"
+	"log"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/events"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeWebhook    = "webhook"
+	TypeSlack      = "slack"
+	TypeMattermost = "mattermost"
+)
+
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+const (
+	MaxAttempts    = 5
+	InitialBackoff = 2 * time.Second
+	RequestTimeout = 10 * time.Second
+	// PollInterval is how often outbox is checked for deliveries which are due to retry
+	PollInterval = time.Second
+)
+
+const SignatureHeader = "X-Mfe-Signature"
+
+// Payload is body of generic webhook, chat formats are rendered from it
+type Payload struct {
+	Event       string  `json:"event"`
+	Status      string  `json:"status"`
+	Project     string  `json:"project"`
+	ProjectName string  `json:"project_name"`
+	Branch      string  `json:"branch"`
+	Revision    string  `json:"revision"`
+	JobId       uint    `json:"job_id"`
+	Duration    float64 `json:"duration"`
+	ManifestUrl string  `json:"manifest_url"`
+	LogUrl      string  `json:"log_url"`
+	Error       string  `json:"error,omitempty"`
+}
+
+type Notifier struct {
+	dbDriver     *dbDriver.DBDriver
+	configMap    *configMap.ConfigMap
+	events       *events.Bus
+	gitlabClient *gitlab.Client
+	client       *http.Client
+	backoff      time.Duration
+	pollInterval time.Duration
+	wake         chan struct{}
+}
+
+// HandleJobResult puts notifications of finished job to outbox, it is called by queue before job state is saved,
+// so result of job is either in outbox or job is run again after restart
+func (n *Notifier) HandleJobResult(job dbDriver.Job, err error) {
+	status := ""
+	eventType := ""
+	switch job.Status {
+	case dbDriver.JobStatusDone:
+		status, eventType = StatusSucceeded, events.TypeBuildSucceeded
+	case dbDriver.JobStatusFailed:
+		status, eventType = StatusFailed, events.TypeBuildFailed
+	default:
+		return
+	}
+
+	project := n.configMap.FindProject(job.ProjectId)
+	if project == nil || len(project.Notifications) == 0 {
+		return
+	}
+
+	payload := n.payload(project, job, eventType, status, err)
+	for _, notification := range project.Notifications {
+		if len(notification.Events) > 0 && !lo.Contains(notification.Events, status) {
+			continue
+		}
+
+		if err := n.enqueue(notification, payload); err != nil {
+			log.Printf("failed on put %s notification of job #%d to outbox: %s", notification.Type, job.ID, err)
+		}
+	}
+
+	n.signal()
+}
+
+// StartWorker reports build states to gitlab and sends pending notifications from outbox,
+// deliveries left by previous run of worker are sent too
+func (n *Notifier) StartWorker() {
+	n.startGitlabReporter()
+
+	go func() {
+		ticker := time.NewTicker(n.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			n.deliverDue()
+
+			select {
+			case <-ticker.C:
+			case <-n.wake:
+			}
+		}
+	}()
+}
+
+func (n *Notifier) signal() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Notifier) payload(project *configMap.Project, job dbDriver.Job, eventType string, status string, err error) Payload {
+	payload := Payload{
+		Event:       eventType,
+		Status:      status,
+		Project:     project.ProjectID,
+		ProjectName: project.ProjectName,
+		Branch:      job.Branch,
+		Revision:    job.Commit,
+		JobId:       job.ID,
+		ManifestUrl: n.manifestUrl(job.ProjectId, job.Branch, job.Tag, job.Commit),
+		LogUrl:      n.logUrl(job.ProjectId, job.Branch, job.Tag, job.Commit),
+	}
+
+	if err != nil {
+		payload.Error = err.Error()
+	}
+
+	if job.StartedAt != nil && job.FinishedAt != nil {
+		payload.Duration = job.FinishedAt.Sub(*job.StartedAt).Seconds()
+	}
+
+	return payload
+}
+
+func (n *Notifier) manifestUrl(projectId, branch string, tag bool, revision string) string {
+	return fmt.Sprintf("%s/manifest/%s/%s/%s", n.configMap.HttpBaseUrl, projectId, refPath(branch, tag), revision)
+}
+
+func (n *Notifier) logUrl(projectId, branch string, tag bool, revision string) string {
+	return fmt.Sprintf("%s/builds/%s/%s/%s/log", n.configMap.HttpBaseUrl, projectId, refPath(branch, tag), revision)
+}
+
+// refPath is part of api url with branch or tag, tags are served under own prefix
+func refPath(name string, tag bool) string {
+	if tag {
+		return "tags/" + name
+	}
+
+	return name
+}
+
+func (n *Notifier) enqueue(notification configMap.Notification, payload Payload) error {
+	body, err := render(notification.Type, payload)
+	if err != nil {
+		return err
+	}
+
+	signature := ""
+	if len(notification.Secret) > 0 {
+		signature = Sign(notification.Secret, body)
+	}
+
+	now := time.Now()
+	_, err = n.dbDriver.CreateNotificationDelivery(&dbDriver.NotificationDelivery{
+		ProjectId:     payload.Project,
+		Branch:        payload.Branch,
+		Revision:      payload.Revision,
+		JobId:         payload.JobId,
+		Event:         payload.Event,
+		Type:          notification.Type,
+		Target:        target(notification.Url),
+		Url:           notification.Url,
+		Body:          string(body),
+		Signature:     signature,
+		NextAttemptAt: &now,
+	})
+
+	return err
+}
+
+// deliverDue sends due deliveries in parallel and waits for all of them,
+// so next load of outbox never sees delivery which is being sent
+func (n *Notifier) deliverDue() {
+	deliveries, err := n.dbDriver.GetDueNotificationDeliveries(time.Now())
+	if err != nil {
+		log.Printf("failed on load notification outbox: %s", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery *dbDriver.NotificationDelivery) {
+			defer wg.Done()
+			n.attempt(delivery)
+		}(delivery)
+	}
+
+	wg.Wait()
+}
+
+// attempt sends delivery once and saves its result, next attempt is scheduled with exponential backoff
+func (n *Notifier) attempt(delivery *dbDriver.NotificationDelivery) {
+	statusCode, err := n.send(delivery)
+
+	delivery.Attempts++
+	delivery.StatusCode = statusCode
+	delivery.Delivered = err == nil
+	delivery.Error = ""
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	if !delivery.Delivered {
+		if retryable(statusCode) && delivery.Attempts < MaxAttempts {
+			nextAttemptAt := time.Now().Add(n.backoff << (delivery.Attempts - 1))
+			delivery.NextAttemptAt = &nextAttemptAt
+		} else {
+			delivery.Failed = true
+			log.Printf("notification of job #%d to %s was not delivered: %s", delivery.JobId, delivery.Target, delivery.Error)
+		}
+	}
+
+	if _, err := n.dbDriver.SaveNotificationDelivery(delivery); err != nil {
+		log.Printf("failed on save notification delivery: %s", err)
+	}
+}
+
+func (n *Notifier) send(delivery *dbDriver.NotificationDelivery) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, delivery.Url, strings.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Mfe-Event", delivery.Event)
+
+	if len(delivery.Signature) > 0 {
+		request.Header.Set(SignatureHeader, delivery.Signature)
+	}
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver responded with status %d", response.StatusCode)
+	}
+
+	return response.StatusCode, nil
+}
+
+// Sign returns value of signature header, receiver compares it with HMAC-SHA256 of raw body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryable is false for client errors, request will not become correct after retry
+func retryable(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// target hides path and query of url in delivery log, incoming webhook urls of chats contain secrets
+func target(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+func render(notificationType string, payload Payload) ([]byte, error) {
+	switch notificationType {
+	case TypeWebhook, "":
+		return json.Marshal(payload)
+	case TypeSlack:
+		return json.Marshal(map[string]string{"text": slackText(payload)})
+	case TypeMattermost:
+		return json.Marshal(map[string]string{"text": mattermostText(payload)})
+	default:
+		return nil, fmt.Errorf("unknown notification type `%s`", notificationType)
+	}
+}
+
+func NewNotifier(configMap *configMap.ConfigMap, dbDriver *dbDriver.DBDriver, events *events.Bus, gitlabClient *gitlab.Client) *Notifier {
+	return &Notifier{
+		dbDriver:     dbDriver,
+		configMap:    configMap,
+		events:       events,
+		gitlabClient: gitlabClient,
+		client:       &http.Client{Timeout: RequestTimeout},
+		backoff:      InitialBackoff,
+		pollInterval: PollInterval,
+		wake:         make(chan struct{}, 1),
+	}
+}
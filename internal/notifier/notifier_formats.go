@@ -0,0 +1,45 @@
+package notifier
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+	"time"
+)
+
+func shortRevision(revision string) string {
+	return lo.Substring(revision, 0, 8)
+}
+
+func duration(payload Payload) string {
+	return time.Duration(payload.Duration * float64(time.Second)).Round(time.Second).String()
+}
+
+// slackText uses slack mrkdwn, links are written as <url|title>
+func slackText(payload Payload) string {
+	if payload.Status == StatusSucceeded {
+		return fmt.Sprintf(
+			":white_check_mark: *%s* `%s` @ `%s` built in %s, <%s|manifest>",
+			payload.ProjectName, payload.Branch, shortRevision(payload.Revision), duration(payload), payload.ManifestUrl,
+		)
+	}
+
+	return fmt.Sprintf(
+		":x: *%s* `%s` @ `%s` failed after %s, <%s|log>\n```%s```",
+		payload.ProjectName, payload.Branch, shortRevision(payload.Revision), duration(payload), payload.LogUrl, payload.Error,
+	)
+}
+
+// mattermostText uses markdown, links are written as [title](url)
+func mattermostText(payload Payload) string {
+	if payload.Status == StatusSucceeded {
+		return fmt.Sprintf(
+			":white_check_mark: **%s** `%s` @ `%s` built in %s, [manifest](%s)",
+			payload.ProjectName, payload.Branch, shortRevision(payload.Revision), duration(payload), payload.ManifestUrl,
+		)
+	}
+
+	return fmt.Sprintf(
+		":x: **%s** `%s` @ `%s` failed after %s, [log](%s)\n```\n%s\n```",
+		payload.ProjectName, payload.Branch, shortRevision(payload.Revision), duration(payload), payload.LogUrl, payload.Error,
+	)
+}
@@ -0,0 +1,184 @@
+package notifier
+
+import (
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"log"
+	"mfe-worker/internal/events"
+	"strings"
+)
+
+const CommitStatusName = "mfe-worker"
+
+// maxNoteFiles limits preview links in merge request note, chunks of big builds are not useful there
+const maxNoteFiles = 20
+
+// noteMarker is hidden in markdown of merge request note, so note of worker is found and updated by next builds
+const noteMarker = "<!-- mfe-worker -->"
+
+var commitStates = map[string]gitlab.BuildStateValue{
+	events.TypeBuildQueued:    gitlab.Pending,
+	events.TypeBuildStarted:   gitlab.Running,
+	events.TypeBuildSucceeded: gitlab.Success,
+	events.TypeBuildFailed:    gitlab.Failed,
+	events.TypeBuildCancelled: gitlab.Canceled,
+}
+
+// startGitlabReporter handles events one by one, so statuses of commit are posted in order they happened,
+// subscription is reliable, so slow gitlab delays statuses instead of losing them
+func (n *Notifier) startGitlabReporter() {
+	live, _ := n.events.SubscribeReliable(events.Filter{})
+
+	go func() {
+		for event := range live {
+			state, ok := commitStates[event.Type]
+			if !ok {
+				continue
+			}
+
+			project := n.configMap.FindProject(event.ProjectId)
+			if project == nil {
+				continue
+			}
+
+			if project.GitlabReport.CommitStatus {
+				if err := n.setCommitStatus(event, state); err != nil {
+					log.Printf("failed on set gitlab commit status of %s: %s", event.Revision, err)
+				}
+			}
+
+			if project.GitlabReport.MergeRequestNote && event.Type == events.TypeBuildSucceeded {
+				if err := n.upsertMergeRequestNotes(event); err != nil {
+					log.Printf("failed on update merge request note of %s: %s", event.Revision, err)
+				}
+			}
+		}
+	}()
+}
+
+func (n *Notifier) setCommitStatus(event events.Event, state gitlab.BuildStateValue) error {
+	targetUrl := n.logUrl(event.ProjectId, event.Branch, event.Tag, event.Revision)
+	if state == gitlab.Success {
+		targetUrl = n.manifestUrl(event.ProjectId, event.Branch, event.Tag, event.Revision)
+	}
+
+	description := fmt.Sprintf("build %s", state)
+	if len(event.Error) > 0 {
+		// gitlab limits description of status to 255 chars
+		description = truncate(event.Error, 255)
+	}
+
+	_, _, err := n.gitlabClient.Commits.SetCommitStatus(event.ProjectId, event.Revision, &gitlab.SetCommitStatusOptions{
+		State:       state,
+		Ref:         gitlab.String(event.Branch),
+		Name:        gitlab.String(CommitStatusName),
+		TargetURL:   gitlab.String(targetUrl),
+		Description: gitlab.String(description),
+	})
+
+	return err
+}
+
+// upsertMergeRequestNotes keeps single note of worker in each opened merge request of revision,
+// note is updated by every successful build instead of adding new one
+func (n *Notifier) upsertMergeRequestNotes(event events.Event) error {
+	mergeRequests, _, err := n.gitlabClient.Commits.ListMergeRequestsByCommit(event.ProjectId, event.Revision)
+	if err != nil {
+		return err
+	}
+
+	var note string
+	for _, mergeRequest := range mergeRequests {
+		if mergeRequest.State != "opened" {
+			continue
+		}
+
+		if len(note) == 0 {
+			if note, err = n.mergeRequestNote(event); err != nil {
+				return err
+			}
+		}
+
+		noteId, err := n.findMergeRequestNote(event.ProjectId, mergeRequest.IID)
+		if err != nil {
+			return err
+		}
+
+		if noteId > 0 {
+			_, _, err = n.gitlabClient.Notes.UpdateMergeRequestNote(event.ProjectId, mergeRequest.IID, noteId, &gitlab.UpdateMergeRequestNoteOptions{
+				Body: gitlab.String(note),
+			})
+		} else {
+			_, _, err = n.gitlabClient.Notes.CreateMergeRequestNote(event.ProjectId, mergeRequest.IID, &gitlab.CreateMergeRequestNoteOptions{
+				Body: gitlab.String(note),
+			})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findMergeRequestNote returns id of note with marker of worker or 0 when merge request has no such note
+func (n *Notifier) findMergeRequestNote(projectId string, mergeRequestIid int) (int, error) {
+	options := &gitlab.ListMergeRequestNotesOptions{ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1}}
+
+	for {
+		notes, response, err := n.gitlabClient.Notes.ListMergeRequestNotes(projectId, mergeRequestIid, options)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, note := range notes {
+			if !note.System && strings.Contains(note.Body, noteMarker) {
+				return note.ID, nil
+			}
+		}
+
+		if response.NextPage == 0 {
+			return 0, nil
+		}
+
+		options.Page = response.NextPage
+	}
+}
+
+func (n *Notifier) mergeRequestNote(event events.Event) (string, error) {
+	revision, err := n.dbDriver.FindRefRevision(event.ProjectId, event.Branch, event.Tag, event.Revision)
+	if err != nil {
+		return "", err
+	}
+
+	build, err := n.dbDriver.GetBuildWithFiles(revision.ID)
+	if err != nil {
+		return "", err
+	}
+
+	var note strings.Builder
+	fmt.Fprintf(&note, "%s\n", noteMarker)
+	fmt.Fprintf(&note, "MFE build of `%s` is ready: [manifest](%s)\n\n", shortRevision(event.Revision), n.manifestUrl(event.ProjectId, event.Branch, event.Tag, event.Revision))
+
+	for index, file := range build.Files {
+		if index == maxNoteFiles {
+			fmt.Fprintf(&note, "- ...and %d more files\n", len(build.Files)-maxNoteFiles)
+			break
+		}
+
+		fmt.Fprintf(&note, "- [%s](%s)\n", file.Path, file.WebPath)
+	}
+
+	return note.String(), nil
+}
+
+// truncate cuts value to length of chars, multibyte chars are never split
+func truncate(value string, length int) string {
+	chars := []rune(value)
+	if len(chars) <= length {
+		return value
+	}
+
+	return string(chars[:length-3]) + "..."
+}
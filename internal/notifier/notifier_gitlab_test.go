@@ -0,0 +1,199 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/events"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+const testRevision = "0123456789abcdef0123456789abcdef01234567"
+
+// gitlabStub implements api calls of reporter for project 1 with opened merge request 5 and merged one 6
+type gitlabStub struct {
+	mu       sync.Mutex
+	states   []string
+	notes    map[int]string
+	lastNote int
+	created  int
+	updated  int
+}
+
+func (g *gitlabStub) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	path := strings.TrimPrefix(request.URL.Path, "/api/v4/projects/1")
+	writer.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case request.Method == http.MethodPost && path == "/statuses/"+testRevision:
+		var options map[string]string
+		_ = json.NewDecoder(request.Body).Decode(&options)
+		g.states = append(g.states, options["state"])
+		fmt.Fprint(writer, `{"id": 1}`)
+	case request.Method == http.MethodGet && path == "/repository/commits/"+testRevision+"/merge_requests":
+		fmt.Fprint(writer, `[{"iid": 5, "state": "opened"}, {"iid": 6, "state": "merged"}]`)
+	case request.Method == http.MethodGet && path == "/merge_requests/5/notes":
+		var notes []map[string]any
+		notes = append(notes, map[string]any{"id": 1, "body": "looks good", "system": false})
+		for id, body := range g.notes {
+			notes = append(notes, map[string]any{"id": id, "body": body, "system": false})
+		}
+		_ = json.NewEncoder(writer).Encode(notes)
+	case request.Method == http.MethodPost && path == "/merge_requests/5/notes":
+		var options map[string]string
+		_ = json.NewDecoder(request.Body).Decode(&options)
+		g.lastNote++
+		g.notes[100+g.lastNote] = options["body"]
+		g.created++
+		fmt.Fprintf(writer, `{"id": %d}`, 100+g.lastNote)
+	case request.Method == http.MethodPut && strings.HasPrefix(path, "/merge_requests/5/notes/"):
+		var options map[string]string
+		_ = json.NewDecoder(request.Body).Decode(&options)
+		var id int
+		_, _ = fmt.Sscanf(strings.TrimPrefix(path, "/merge_requests/5/notes/"), "%d", &id)
+		g.notes[id] = options["body"]
+		g.updated++
+		fmt.Fprintf(writer, `{"id": %d}`, id)
+	default:
+		writer.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(writer, `{"message": "unexpected %s %s"}`, request.Method, request.URL.Path)
+	}
+}
+
+func (g *gitlabStub) snapshot() (states []string, notes map[int]string, created int, updated int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	notes = map[int]string{}
+	for id, body := range g.notes {
+		notes[id] = body
+	}
+
+	return append([]string{}, g.states...), notes, g.created, g.updated
+}
+
+func newReporter(t *testing.T, stub *gitlabStub) (*Notifier, *events.Bus) {
+	t.Helper()
+
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	config := &configMap.ConfigMap{
+		DBPath:      filepath.Join(t.TempDir(), "db.sqlite"),
+		HttpBaseUrl: "http://mfe.local",
+		Projects: []configMap.Project{{
+			ProjectID:    "1",
+			ProjectName:  "app",
+			GitlabReport: configMap.GitlabReport{CommitStatus: true, MergeRequestNote: true},
+		}},
+	}
+
+	db, err := dbDriver.NewDBDriver(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	branch, err := db.CreateBranch(&dbDriver.Branch{Name: "main", ProjectId: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revision, err := db.CreateRevision(&dbDriver.Revision{Name: testRevision, BranchId: branch.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.CreateBuild(&dbDriver.Build{RevisionId: revision.ID, Status: dbDriver.BuildStatusReady, Files: []dbDriver.BuildFiles{
+		{Path: "dist/app.js", WebPath: "http://mfe.local/static/app.js"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := gitlab.NewClient("token", gitlab.WithBaseURL(server.URL+"/api/v4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := events.NewBus()
+	n := NewNotifier(config, db, bus, client)
+	n.startGitlabReporter()
+
+	return n, bus
+}
+
+func publish(bus *events.Bus, eventType string) {
+	bus.Publish(events.Event{Type: eventType, ProjectId: "1", Branch: "main", Revision: testRevision, JobId: 1})
+}
+
+func TestCommitStatusFollowsBuild(t *testing.T) {
+	stub := &gitlabStub{notes: map[int]string{}}
+	_, bus := newReporter(t, stub)
+
+	publish(bus, events.TypeBuildQueued)
+	publish(bus, events.TypeBuildStarted)
+	publish(bus, events.TypeBuildStep)
+	publish(bus, events.TypeBuildSucceeded)
+
+	waitFor(t, func() bool {
+		states, _, created, _ := stub.snapshot()
+		return len(states) == 3 && created == 1
+	})
+
+	states, _, _, _ := stub.snapshot()
+	if strings.Join(states, ",") != "pending,running,success" {
+		t.Fatalf("unexpected states %v", states)
+	}
+}
+
+func TestMergeRequestNoteIsUpdated(t *testing.T) {
+	stub := &gitlabStub{notes: map[int]string{}}
+	_, bus := newReporter(t, stub)
+
+	publish(bus, events.TypeBuildSucceeded)
+	waitFor(t, func() bool {
+		_, _, created, _ := stub.snapshot()
+		return created == 1
+	})
+
+	publish(bus, events.TypeBuildSucceeded)
+	waitFor(t, func() bool {
+		_, _, _, updated := stub.snapshot()
+		return updated == 1
+	})
+
+	_, notes, created, _ := stub.snapshot()
+	if created != 1 || len(notes) != 1 {
+		t.Fatalf("expected single note, created %d, notes %v", created, notes)
+	}
+
+	for _, body := range notes {
+		if !strings.Contains(body, noteMarker) || !strings.Contains(body, "[dist/app.js](http://mfe.local/static/app.js)") {
+			t.Fatalf("unexpected note %s", body)
+		}
+	}
+}
+
+func TestTruncateKeepsRunes(t *testing.T) {
+	value := strings.Repeat("сборка ", 50)
+
+	truncated := truncate(value, 255)
+	if !utf8.ValidString(truncated) || utf8.RuneCountInString(truncated) != 255 || !strings.HasSuffix(truncated, "...") {
+		t.Fatalf("unexpected truncated value %q", truncated)
+	}
+
+	if truncate("short", 255) != "short" {
+		t.Fatal("short value was changed")
+	}
+}
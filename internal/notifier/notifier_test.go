@@ -0,0 +1,222 @@
+package notifier
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/events"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedRequest struct {
+	event     string
+	signature string
+	body      []byte
+}
+
+// receiver answers with given statuses one by one and then with 200
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedRequest
+}
+
+func (r *receiver) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	body, _ := io.ReadAll(request.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, receivedRequest{
+		event:     request.Header.Get("X-Mfe-Event"),
+		signature: request.Header.Get(SignatureHeader),
+		body:      body,
+	})
+
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+
+	writer.WriteHeader(status)
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]receivedRequest{}, r.requests...)
+}
+
+func newTestNotifier(t *testing.T, dbPath string, notification configMap.Notification) *Notifier {
+	t.Helper()
+
+	config := &configMap.ConfigMap{
+		DBPath:      dbPath,
+		HttpBaseUrl: "http://mfe.local",
+		Projects: []configMap.Project{{
+			ProjectID:     "1",
+			ProjectName:   "app",
+			Notifications: []configMap.Notification{notification},
+		}},
+	}
+
+	db, err := dbDriver.NewDBDriver(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNotifier(config, db, events.NewBus(), nil)
+	n.backoff = 10 * time.Millisecond
+	n.pollInterval = 10 * time.Millisecond
+	return n
+}
+
+func finishedJob(status dbDriver.JobStatus) dbDriver.Job {
+	finishedAt := time.Now()
+	startedAt := finishedAt.Add(-time.Minute)
+
+	job := dbDriver.Job{ProjectId: "1", Branch: "main", Commit: "0123456789abcdef", Status: status, StartedAt: &startedAt, FinishedAt: &finishedAt}
+	job.ID = 7
+	return job
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met in time")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func deliveries(t *testing.T, n *Notifier) []*dbDriver.NotificationDelivery {
+	t.Helper()
+
+	list, err := n.dbDriver.GetJobNotificationDeliveries(finishedJob(dbDriver.JobStatusDone).ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return list
+}
+
+func TestWebhookIsRetriedAndSigned(t *testing.T) {
+	target := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	server := httptest.NewServer(target)
+	defer server.Close()
+
+	n := newTestNotifier(t, filepath.Join(t.TempDir(), "db.sqlite"), configMap.Notification{Type: TypeWebhook, Url: server.URL + "/hook?secret=1", Secret: "s3cret"})
+	n.StartWorker()
+	n.HandleJobResult(finishedJob(dbDriver.JobStatusFailed), errors.New("npm exited with 1"))
+
+	waitFor(t, func() bool { return len(target.received()) == 3 })
+
+	requests := target.received()
+	for _, request := range requests {
+		if request.signature != Sign("s3cret", request.body) {
+			t.Fatalf("wrong signature %s", request.signature)
+		}
+
+		if request.event != events.TypeBuildFailed {
+			t.Fatalf("wrong event header %s", request.event)
+		}
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(requests[0].body, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload.Status != StatusFailed || payload.Error != "npm exited with 1" || payload.Duration != 60 ||
+		payload.LogUrl != "http://mfe.local/builds/1/main/0123456789abcdef/log" {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+
+	waitFor(t, func() bool {
+		list := deliveries(t, n)
+		return len(list) == 1 && list[0].Delivered
+	})
+
+	delivery := deliveries(t, n)[0]
+	if delivery.Attempts != 3 || delivery.StatusCode != http.StatusOK || delivery.Target != server.URL {
+		t.Fatalf("unexpected delivery %+v", delivery)
+	}
+}
+
+func TestClientErrorIsNotRetried(t *testing.T) {
+	target := &receiver{statuses: []int{http.StatusNotFound}}
+	server := httptest.NewServer(target)
+	defer server.Close()
+
+	n := newTestNotifier(t, filepath.Join(t.TempDir(), "db.sqlite"), configMap.Notification{Type: TypeSlack, Url: server.URL})
+	n.StartWorker()
+	n.HandleJobResult(finishedJob(dbDriver.JobStatusDone), nil)
+
+	waitFor(t, func() bool {
+		list := deliveries(t, n)
+		return len(list) == 1 && list[0].Failed
+	})
+
+	if count := len(target.received()); count != 1 {
+		t.Fatalf("expected single request, got %d", count)
+	}
+
+	var body map[string]string
+	if err := json.Unmarshal(target.received()[0].body, &body); err != nil || len(body["text"]) == 0 {
+		t.Fatalf("unexpected slack body %s", target.received()[0].body)
+	}
+}
+
+func TestPendingDeliveryIsSentAfterRestart(t *testing.T) {
+	target := &receiver{}
+	server := httptest.NewServer(target)
+	defer server.Close()
+
+	dbPath := filepath.Join(t.TempDir(), "db.sqlite")
+	notification := configMap.Notification{Type: TypeWebhook, Url: server.URL, Events: []string{StatusSucceeded}}
+
+	// worker was stopped after job result was put to outbox
+	stopped := newTestNotifier(t, dbPath, notification)
+	stopped.HandleJobResult(finishedJob(dbDriver.JobStatusDone), nil)
+	stopped.HandleJobResult(finishedJob(dbDriver.JobStatusFailed), errors.New("filtered out by events"))
+
+	if count := len(target.received()); count != 0 {
+		t.Fatalf("notification was sent without worker: %d", count)
+	}
+
+	restarted := newTestNotifier(t, dbPath, notification)
+	restarted.StartWorker()
+
+	waitFor(t, func() bool { return len(target.received()) == 1 })
+
+	time.Sleep(50 * time.Millisecond)
+	if count := len(target.received()); count != 1 {
+		t.Fatalf("expected single delivery, got %d", count)
+	}
+}
+
+func TestUrlsOfTagBuild(t *testing.T) {
+	n := newTestNotifier(t, filepath.Join(t.TempDir(), "db.sqlite"), configMap.Notification{Type: TypeWebhook, Url: "http://hooks.local"})
+
+	job := finishedJob(dbDriver.JobStatusDone)
+	job.Branch = "v1.0.0"
+	job.Tag = true
+
+	payload := n.payload(n.configMap.FindProject("1"), job, events.TypeBuildSucceeded, StatusSucceeded, nil)
+	if payload.ManifestUrl != "http://mfe.local/manifest/1/tags/v1.0.0/0123456789abcdef" ||
+		payload.LogUrl != "http://mfe.local/builds/1/tags/v1.0.0/0123456789abcdef/log" {
+		t.Fatalf("unexpected urls %+v", payload)
+	}
+}
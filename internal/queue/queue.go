@@ -1,73 +1,453 @@
 package queue
 
 import (
+	"context"
+	"errors"
 	"github.com/samber/lo"
 	"log"
 	"mfe-worker/internal/configMap"
+	"mfe-worker/internal/dbDriver"
+	"mfe-worker/internal/events"
+	"sort"
 	"sync"
 	"time"
 )
 
-type Status uint
+const DefaultWorkers = 5
+
+type Lane uint
 
 const (
-	StatusLock Status = iota
-	StatusFree        = iota
+	LaneDefault Lane = iota
+	LanePriority
 )
 
-const Length = 5
+// ErrCancelled is cause of context of job which was cancelled by request
+var ErrCancelled = errors.New("job was cancelled")
+
+// ErrSuperseded is cause of context of job which was cancelled because newer commit of branch was queued
+var ErrSuperseded = errors.New("job was superseded by newer commit")
+
+type Runner func(ctx context.Context, job *dbDriver.Job) error
 
-type Worker func(wg *sync.WaitGroup) error
+// FinishHandler gets copy of job which runner has finished, it is called synchronously by worker of job,
+// so nothing is lost when consumer is slow, it only holds the worker
+type FinishHandler func(job dbDriver.Job, err error)
+
+// Entry is snapshot of job in queue, Position is 1-based order of dispatch of waiting job and 0 for running one
+type Entry struct {
+	Job      dbDriver.Job
+	Lane     Lane
+	Position int
+}
+
+type runningJob struct {
+	job    *dbDriver.Job
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
 
 type Queue struct {
-	queue       []Worker
-	configMap   *configMap.ConfigMap
-	queueStatus Status
+	mu        sync.Mutex
+	queue     []*dbDriver.Job
+	running   map[uint]*runningJob
+	projects  map[string]int
+	runner    Runner
+	finished  []FinishHandler
+	dbDriver  *dbDriver.DBDriver
+	configMap *configMap.ConfigMap
+	events    *events.Bus
+	jobs      chan *runningJob
+	slots     chan struct{}
+	notify    chan struct{}
 }
 
+// StartQueueWorker starts pool of workers and dispatcher which feeds them,
+// job is dispatched only when there is free worker, so lanes are applied to all waiting jobs
 func (q *Queue) StartQueueWorker() {
-	ticker := time.NewTicker(5 * time.Second)
+	workers := cap(q.slots)
+
+	for index := 0; index < workers; index++ {
+		q.slots <- struct{}{}
+		go q.worker()
+	}
 
 	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				if q.queueStatus == StatusLock {
-					continue
-				}
-
-				q.queueStatus = StatusLock
-
-				var wg sync.WaitGroup
-				var batch = lo.Slice(q.queue, 0, Length)
-
-				for _, task := range batch {
-					wg.Add(1)
-					task := task
-					go func() {
-						err := task(&wg)
-						if err != nil {
-							log.Printf("queue task error: %s", err)
-						}
-					}()
-				}
-
-				wg.Wait()
-
-				q.queue = lo.Slice(q.queue, len(batch), len(q.queue))
-				q.queueStatus = StatusFree
-			}
+		for range q.slots {
+			q.jobs <- q.waitNext()
 		}
 	}()
+
+	log.Printf("queue started with %d workers", workers)
+}
+
+func (q *Queue) worker() {
+	for item := range q.jobs {
+		q.runJob(item)
+
+		q.mu.Lock()
+		delete(q.running, item.job.ID)
+		q.projects[item.job.ProjectId]--
+		q.mu.Unlock()
+
+		q.slots <- struct{}{}
+
+		// finished job could release project cap for waiting jobs
+		q.signal()
+	}
+}
+
+// waitNext blocks until there is job which could be started
+func (q *Queue) waitNext() *runningJob {
+	for {
+		q.mu.Lock()
+		item := q.pickLocked()
+		q.mu.Unlock()
+
+		if item != nil {
+			return item
+		}
+
+		<-q.notify
+	}
+}
+
+// pickLocked takes first job of the highest lane which project has free slots and marks it as running
+func (q *Queue) pickLocked() *runningJob {
+	pickedIndex := -1
+	pickedLane := LaneDefault
+
+	for index, job := range q.queue {
+		project := q.configMap.FindProject(job.ProjectId)
+		if project != nil && project.MaxConcurrentBuilds > 0 && q.projects[job.ProjectId] >= project.MaxConcurrentBuilds {
+			continue
+		}
+
+		lane := q.lane(project, job)
+		if pickedIndex == -1 || lane > pickedLane {
+			pickedIndex = index
+			pickedLane = lane
+		}
+	}
+
+	if pickedIndex == -1 {
+		return nil
+	}
+
+	job := q.queue[pickedIndex]
+	q.queue = append(q.queue[:pickedIndex], q.queue[pickedIndex+1:]...)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	item := &runningJob{job: job, ctx: ctx, cancel: cancel}
+
+	now := time.Now()
+	job.Status = dbDriver.JobStatusRunning
+	job.StartedAt = &now
+	q.running[job.ID] = item
+	q.projects[job.ProjectId]++
+
+	return item
+}
+
+func (q *Queue) lane(project *configMap.Project, job *dbDriver.Job) Lane {
+	if project != nil && project.IsPriorityBranch(job.Branch) {
+		return LanePriority
+	}
+
+	return LaneDefault
+}
+
+// signal wakes dispatcher, it never blocks because one pending wake up is enough
+func (q *Queue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// runJob runs job and saves its state, job of item is changed only under lock, because Entries reads it,
+// so database and runner get copies of it
+func (q *Queue) runJob(item *runningJob) {
+	defer item.cancel(nil)
+
+	q.mu.Lock()
+	startedJob := *item.job
+	q.mu.Unlock()
+
+	q.saveJob(startedJob)
+	q.publish(events.TypeBuildStarted, startedJob, nil)
+
+	err := q.runner(item.ctx, &startedJob)
+	if err != nil {
+		log.Printf("queue task error: %s", err)
+	}
+
+	// cancel which came after runner has succeeded doesn't change result of job
+	status := lo.Ternary[dbDriver.JobStatus](err == nil, dbDriver.JobStatusDone, dbDriver.JobStatusFailed)
+	if err != nil {
+		switch cause := context.Cause(item.ctx); {
+		case errors.Is(cause, ErrCancelled):
+			status = dbDriver.JobStatusCancelled
+		case errors.Is(cause, ErrSuperseded):
+			status = dbDriver.JobStatusSuperseded
+		}
+	}
+
+	now := time.Now()
+
+	q.mu.Lock()
+	item.job.Status = status
+	item.job.FinishedAt = &now
+	finishedJob := *item.job
+	q.mu.Unlock()
+
+	// handlers are called before final state is saved, so job which result wasn't handled is restored after restart
+	for _, handler := range q.finished {
+		handler(finishedJob, err)
+	}
+
+	q.saveJob(finishedJob)
+
+	switch status {
+	case dbDriver.JobStatusDone:
+		q.publish(events.TypeBuildSucceeded, finishedJob, nil)
+	case dbDriver.JobStatusFailed:
+		q.publish(events.TypeBuildFailed, finishedJob, err)
+	default:
+		q.publish(events.TypeBuildCancelled, finishedJob, context.Cause(item.ctx))
+	}
+}
+
+// saveJob takes copy of job, so fields which are set by database don't race with readers of queued job
+func (q *Queue) saveJob(job dbDriver.Job) {
+	if _, err := q.dbDriver.SaveJob(&job); err != nil {
+		log.Printf("failed on save job state: %s", err)
+	}
+}
+
+func (q *Queue) publish(eventType string, job dbDriver.Job, err error) {
+	event := events.Event{
+		Type:      eventType,
+		ProjectId: job.ProjectId,
+		Branch:    job.Branch,
+		Tag:       job.Tag,
+		Revision:  job.Commit,
+		JobId:     job.ID,
+	}
+
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	q.events.Publish(event)
+}
+
+// Cancel aborts running job of revision or removes queued one from queue,
+// running is true when job was already started and its runner is responsible for final state
+func (q *Queue) Cancel(revisionId uint) (found bool, running bool) {
+	q.mu.Lock()
+
+	for _, item := range q.running {
+		if item.job.RevisionId == revisionId {
+			item.cancel(ErrCancelled)
+			q.mu.Unlock()
+			return true, true
+		}
+	}
+
+	for index, job := range q.queue {
+		if job.RevisionId == revisionId {
+			removedJob := q.removeLocked(index)
+			q.mu.Unlock()
+
+			q.finishRemoved(removedJob)
+			return true, false
+		}
+	}
+
+	q.mu.Unlock()
+	return false, false
+}
+
+// Remove drops waiting job from queue, nil is returned when job is not waiting
+func (q *Queue) Remove(jobId uint) *dbDriver.Job {
+	q.mu.Lock()
+
+	for index, job := range q.queue {
+		if job.ID == jobId {
+			removedJob := q.removeLocked(index)
+			q.mu.Unlock()
+
+			q.finishRemoved(removedJob)
+			return &removedJob
+		}
+	}
+
+	q.mu.Unlock()
+	return nil
+}
+
+// removeLocked drops job from queue and returns its copy, state of it is saved by finishRemoved out of lock
+func (q *Queue) removeLocked(index int) dbDriver.Job {
+	job := q.queue[index]
+	q.queue = append(q.queue[:index], q.queue[index+1:]...)
+
+	job.Status = dbDriver.JobStatusCancelled
+	return *job
+}
+
+func (q *Queue) finishRemoved(job dbDriver.Job) {
+	q.saveJob(job)
+	q.publish(events.TypeBuildCancelled, job, ErrCancelled)
+}
+
+// Entries returns running jobs and then waiting jobs in order of dispatch, project caps are not taken into account
+func (q *Queue) Entries() []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var entries []Entry
+	for _, item := range q.running {
+		entries = append(entries, Entry{Job: *item.job, Lane: q.lane(q.configMap.FindProject(item.job.ProjectId), item.job)})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Job.ID < entries[j].Job.ID
+	})
+
+	var waiting []Entry
+	for _, job := range q.queue {
+		waiting = append(waiting, Entry{Job: *job, Lane: q.lane(q.configMap.FindProject(job.ProjectId), job)})
+	}
+
+	sort.SliceStable(waiting, func(i, j int) bool {
+		return waiting[i].Lane > waiting[j].Lane
+	})
+
+	for index := range waiting {
+		waiting[index].Position = index + 1
+	}
+
+	return append(entries, waiting...)
+}
+
+func (q *Queue) SetRunner(runner Runner) {
+	q.runner = runner
+}
+
+// OnFinish adds handler of finished jobs, it must be called before StartQueueWorker
+func (q *Queue) OnFinish(handler FinishHandler) {
+	q.finished = append(q.finished, handler)
 }
 
-func (q *Queue) AddToQueue(fn Worker) {
-	q.queue = append(q.queue, fn)
+func (q *Queue) AddToQueue(job *dbDriver.Job) error {
+	job.Status = dbDriver.JobStatusQueued
+	if _, err := q.dbDriver.CreateJob(job); err != nil {
+		return errors.Join(errors.New("failed on persist queue job"), err)
+	}
+
+	// event is published before job could be picked by worker, so it always precedes start event
+	q.publish(events.TypeBuildQueued, *job, nil)
+
+	// queue keeps own copy, job of caller is not changed by workers
+	queuedJob := *job
+
+	q.mu.Lock()
+	q.queue = append(q.queue, &queuedJob)
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
 }
 
-func NewQueue(configMap *configMap.ConfigMap) *Queue {
+// Supersede adds job to queue and drops queued head jobs of the same project branch,
+// running head job of branch is cancelled too when cancelRunning is set, dropped jobs are returned.
+// Pinned jobs, e.g. rebuilds and builds of exact commit, are never superseded
+func (q *Queue) Supersede(job *dbDriver.Job, cancelRunning bool) (superseded []*dbDriver.Job, err error) {
+	job.Status = dbDriver.JobStatusQueued
+	if _, err := q.dbDriver.CreateJob(job); err != nil {
+		return nil, errors.Join(errors.New("failed on persist queue job"), err)
+	}
+
+	q.publish(events.TypeBuildQueued, *job, nil)
+
+	queuedJob := *job
+
+	q.mu.Lock()
+
+	if cancelRunning {
+		for _, item := range q.running {
+			if supersedes(job, item.job) {
+				item.cancel(ErrSuperseded)
+			}
+		}
+	}
+
+	q.queue = lo.Filter(q.queue, func(queued *dbDriver.Job, _ int) bool {
+		if !supersedes(job, queued) {
+			return true
+		}
+
+		queued.Status = dbDriver.JobStatusSuperseded
+		supersededJob := *queued
+		superseded = append(superseded, &supersededJob)
+		return false
+	})
+
+	q.queue = append(q.queue, &queuedJob)
+	q.mu.Unlock()
+
+	for _, supersededJob := range superseded {
+		q.saveJob(*supersededJob)
+		q.publish(events.TypeBuildCancelled, *supersededJob, ErrSuperseded)
+	}
+
+	q.signal()
+	return superseded, nil
+}
+
+// supersedes reports whether head job replaces other job, only head jobs of the same branch or tag are replaced
+func supersedes(job *dbDriver.Job, other *dbDriver.Job) bool {
+	return !other.Pinned && other.ProjectId == job.ProjectId && other.Branch == job.Branch && other.Tag == job.Tag
+}
+
+// RestoreJobs puts back jobs which were queued or running when worker was stopped
+func (q *Queue) RestoreJobs() error {
+	jobs, err := q.dbDriver.GetUnfinishedJobs()
+	if err != nil {
+		return errors.Join(errors.New("failed on load unfinished queue jobs"), err)
+	}
+
+	for _, job := range jobs {
+		job.Status = dbDriver.JobStatusQueued
+		if _, err := q.dbDriver.SaveJob(job); err != nil {
+			return err
+		}
+	}
+
+	if len(jobs) > 0 {
+		log.Printf("restored %d unfinished jobs to queue", len(jobs))
+	}
+
+	q.mu.Lock()
+	q.queue = append(jobs, q.queue...)
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
+}
+
+func NewQueue(configMap *configMap.ConfigMap, dbDriver *dbDriver.DBDriver, events *events.Bus) *Queue {
+	workers := lo.Ternary(configMap.Workers > 0, configMap.Workers, DefaultWorkers)
+
 	return &Queue{
-		configMap:   configMap,
-		queueStatus: StatusFree,
+		running:   map[uint]*runningJob{},
+		projects:  map[string]int{},
+		dbDriver:  dbDriver,
+		configMap: configMap,
+		events:    events,
+		jobs:      make(chan *runningJob),
+		slots:     make(chan struct{}, workers),
+		notify:    make(chan struct{}, 1),
 	}
 }
@@ -1,32 +1,158 @@
 package shell
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"log"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 )
 
+type Stream string
+
+const (
+	StreamStdout Stream = "stdout"
+	StreamStderr Stream = "stderr"
+)
+
+type LineHandler func(stream Stream, line string)
+
 type ExecShellCommandArgs struct {
-	Cwd   string
-	Debug bool
+	Cwd     string
+	Debug   bool
+	OnLine  LineHandler
+	Timeout time.Duration
+	// Env is appended to environment of worker in KEY=VALUE form
+	Env []string
+	// CleanEnv replaces environment of worker with BaseEnv, only Env is passed in addition to it
+	CleanEnv bool
+	// Mask hides secrets in debug output of command
+	Mask func(text string) string
+}
+
+// BaseEnvNames are variables of worker environment kept for commands with clean environment
+var BaseEnvNames = []string{"PATH", "HOME", "USER", "LOGNAME", "LANG", "LC_ALL", "TZ", "TMPDIR"}
+
+func BaseEnv() []string {
+	var env []string
+	for _, name := range BaseEnvNames {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+
+	return env
+}
+
+type lineWriter struct {
+	mu      *sync.Mutex
+	buf     bytes.Buffer
+	out     *bytes.Buffer
+	stream  Stream
+	handler LineHandler
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.out.Write(p)
+	w.buf.Write(p)
+
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// incomplete line, wait for the rest of it
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			break
+		}
+
+		w.handler(w.stream, strings.TrimRight(line, "\r\n"))
+	}
+
+	return len(p), nil
 }
 
-func ExecShellCommand(path string, args []string, eArgs ExecShellCommandArgs) (out string, err error) {
-	cmd := exec.Command(path, args...)
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		w.handler(w.stream, strings.TrimRight(w.buf.String(), "\r\n"))
+		w.buf.Reset()
+	}
+}
+
+// ExitCode returns exit code of failed command or -1 when command wasn't started
+func ExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+func ExecShellCommand(ctx context.Context, path string, args []string, eArgs ExecShellCommandArgs) (out string, err error) {
+	if eArgs.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, eArgs.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	killProcessGroupOnCancel(cmd)
 
 	if len(eArgs.Cwd) > 0 {
 		cmd.Dir = eArgs.Cwd
 	}
 
-	var b []byte
-	b, err = cmd.CombinedOutput()
-	out = string(b)
+	if eArgs.CleanEnv {
+		cmd.Env = append(BaseEnv(), eArgs.Env...)
+	} else if len(eArgs.Env) > 0 {
+		cmd.Env = append(os.Environ(), eArgs.Env...)
+	}
+
+	if eArgs.OnLine != nil {
+		var mu sync.Mutex
+		var combined bytes.Buffer
+
+		stdout := &lineWriter{mu: &mu, out: &combined, stream: StreamStdout, handler: eArgs.OnLine}
+		stderr := &lineWriter{mu: &mu, out: &combined, stream: StreamStderr, handler: eArgs.OnLine}
+
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+
+		err = cmd.Run()
+
+		stdout.flush()
+		stderr.flush()
+		out = combined.String()
+	} else {
+		var b []byte
+		b, err = cmd.CombinedOutput()
+		out = string(b)
+	}
+
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		err = errors.Join(err, ctxErr)
+	}
 
 	if eArgs.Debug {
 		log.Println(strings.Join(cmd.Args[:], " "))
 
 		if err != nil {
-			log.Printf("ExecShellCommand error: %s; %s", err, out)
+			debugOut := out
+			if eArgs.Mask != nil {
+				debugOut = eArgs.Mask(out)
+			}
+
+			log.Printf("ExecShellCommand error: %s; %s", err, debugOut)
 		}
 	}
 
@@ -0,0 +1,241 @@
+package shell
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var ErrEmptyCommand = errors.New("command is empty")
+
+// defaultShell runs string command which has operators or expansions
+const defaultShell = "sh"
+
+var assignmentRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// Command is build command of config, it is written in one of forms:
+//   - "npm run build", which is split into words like POSIX shell does, line with operators or expansions runs through sh
+//   - {"run": "npm ci && npm run build", "shell": "bash"}, which runs through shell
+//   - {"cmd": "npm", "args": ["run", "build"], "cwd": "packages/app", "env": {"NODE_ENV": "production"}}
+type Command struct {
+	Run   string            `json:"run,omitempty"`
+	Shell string            `json:"shell,omitempty"`
+	Cmd   string            `json:"cmd,omitempty"`
+	Args  []string          `json:"args,omitempty"`
+	Cwd   string            `json:"cwd,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+}
+
+// commandFields has no own json methods, it is used for read and write object form of Command
+type commandFields Command
+
+func (c Command) MarshalJSON() ([]byte, error) {
+	if c.isPlain() {
+		return json.Marshal(c.Run)
+	}
+
+	return json.Marshal(commandFields(c))
+}
+
+func (c *Command) UnmarshalJSON(data []byte) error {
+	var line string
+	if err := json.Unmarshal(data, &line); err == nil {
+		*c = Command{Run: line}
+		return c.Validate()
+	}
+
+	var fields commandFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	*c = Command(fields)
+	return c.Validate()
+}
+
+// isPlain is true for command which was written as string
+func (c Command) isPlain() bool {
+	return len(c.Run) > 0 && len(c.Shell) == 0 && len(c.Cmd) == 0 && len(c.Cwd) == 0 && len(c.Env) == 0
+}
+
+func (c Command) Validate() error {
+	if len(c.Run) > 0 && len(c.Cmd) > 0 {
+		return fmt.Errorf("command has both run and cmd: %s", c)
+	}
+
+	if len(c.Run) == 0 && len(c.Cmd) == 0 {
+		return ErrEmptyCommand
+	}
+
+	if len(c.Args) > 0 && len(c.Cmd) == 0 {
+		return fmt.Errorf("args are allowed only with cmd: %s", c)
+	}
+
+	if len(c.Shell) > 0 && len(c.Run) == 0 {
+		return fmt.Errorf("shell is allowed only with run: %s", c)
+	}
+
+	// commands of sandboxed executors could only work inside of build dir
+	if len(c.Cwd) > 0 && !filepath.IsLocal(c.Cwd) {
+		return fmt.Errorf("cwd of command must be relative path inside of repository: %s", c.Cwd)
+	}
+
+	if _, _, _, err := c.Argv(); err != nil {
+		return errors.Join(fmt.Errorf("failed on parse command: %s", c.Run), err)
+	}
+
+	return nil
+}
+
+// Argv returns executable, its arguments and variables of command in KEY=VALUE form
+func (c Command) Argv() (path string, args []string, env []string, err error) {
+	for name, value := range c.Env {
+		env = append(env, name+"="+value)
+	}
+
+	sort.Strings(env)
+
+	if len(c.Cmd) > 0 {
+		return c.Cmd, c.Args, env, nil
+	}
+
+	if len(c.Shell) > 0 {
+		return c.Shell, []string{"-c", c.Run}, env, nil
+	}
+
+	words, needsShell, err := Tokenize(c.Run)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	// globs, tilde and hash are passed as is, as they were before commands were tokenized
+	if needsShell {
+		return defaultShell, []string{"-c", c.Run}, env, nil
+	}
+
+	// leading NAME=value words are variables of command, like in shell
+	for len(words) > 0 && assignmentRegexp.MatchString(words[0]) {
+		env = append(env, words[0])
+		words = words[1:]
+	}
+
+	if len(words) == 0 {
+		return "", nil, nil, ErrEmptyCommand
+	}
+
+	return words[0], words[1:], env, nil
+}
+
+// String returns command as it is shown in build log and events
+func (c Command) String() string {
+	line := c.Run
+	if len(c.Cmd) > 0 {
+		words := []string{Quote(c.Cmd)}
+		for _, arg := range c.Args {
+			words = append(words, Quote(arg))
+		}
+
+		line = strings.Join(words, " ")
+	}
+
+	if len(c.Cwd) > 0 {
+		return fmt.Sprintf("%s (in %s)", line, c.Cwd)
+	}
+
+	return line
+}
+
+// Tokenize splits command line into words by rules of POSIX shell: single quotes keep text as is,
+// backslash escapes next char, in double quotes it escapes only $, `, ", \ and newline.
+// needsShell is true when line has unquoted operators or expansions, which only shell can handle,
+// words of such line are not meaningful. Globs, tilde and hash are kept in words as is
+func Tokenize(line string) (words []string, needsShell bool, err error) {
+	var word strings.Builder
+	inWord := false
+	chars := []rune(line)
+
+	for i := 0; i < len(chars); i++ {
+		char := chars[i]
+
+		switch {
+		case char == ' ' || char == '\t' || char == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case char == '\'':
+			end := i + 1
+			for end < len(chars) && chars[end] != '\'' {
+				end++
+			}
+
+			if end == len(chars) {
+				return nil, false, errors.New("unterminated single quote")
+			}
+
+			word.WriteString(string(chars[i+1 : end]))
+			inWord = true
+			i = end
+		case char == '"':
+			i++
+			for ; i < len(chars) && chars[i] != '"'; i++ {
+				switch {
+				case chars[i] == '\\' && i+1 < len(chars) && strings.ContainsRune("$`\"\\\n", chars[i+1]):
+					i++
+					if chars[i] != '\n' {
+						word.WriteRune(chars[i])
+					}
+				case chars[i] == '$' || chars[i] == '`':
+					needsShell = true
+					word.WriteRune(chars[i])
+				default:
+					word.WriteRune(chars[i])
+				}
+			}
+
+			if i == len(chars) {
+				return nil, false, errors.New("unterminated double quote")
+			}
+
+			inWord = true
+		case char == '\\':
+			if i+1 == len(chars) {
+				return nil, false, errors.New("backslash at end of command")
+			}
+
+			i++
+			// escaped newline continues line
+			if chars[i] != '\n' {
+				word.WriteRune(chars[i])
+				inWord = true
+			}
+		case strings.ContainsRune("|&;<>()$`", char):
+			needsShell = true
+			word.WriteRune(char)
+			inWord = true
+		default:
+			word.WriteRune(char)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, needsShell, nil
+}
+
+// Quote returns value as single word of shell, value without special chars is returned as is
+func Quote(value string) string {
+	if len(value) > 0 && strings.Trim(value, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-") == "" {
+		return value
+	}
+
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,158 @@
+package shell
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		words      []string
+		needsShell bool
+		err        bool
+	}{
+		{name: "plain words", line: "npm run build", words: []string{"npm", "run", "build"}},
+		{name: "repeated spaces and tabs", line: "  npm \t run   build ", words: []string{"npm", "run", "build"}},
+		{name: "empty line", line: "   ", words: nil},
+		{name: "single quotes keep text as is", line: `node -e 'console.log("a b", $HOME, \n)'`, words: []string{"node", "-e", `console.log("a b", $HOME, \n)`}},
+		{name: "double quotes", line: `echo "a  b" "c'd"`, words: []string{"echo", "a  b", "c'd"}},
+		{name: "escapes in double quotes", line: `echo "a \"b\" \\ \$x \n"`, words: []string{"echo", `a "b" \ $x \n`}},
+		{name: "quotes join with word", line: `--name="my app"'!'`, words: []string{"--name=my app!"}},
+		{name: "empty quotes are word", line: `echo "" ''`, words: []string{"echo", "", ""}},
+		{name: "backslash escapes", line: `echo a\ b \"c\" \|`, words: []string{"echo", "a b", `"c"`, "|"}},
+		{name: "line continuation", line: "npm run \\\nbuild", words: []string{"npm", "run", "build"}},
+		{name: "line continuation in double quotes", line: "echo \"a\\\nb\"", words: []string{"echo", "ab"}},
+		{name: "assignments are words", line: "NODE_ENV=production CI=1 npm ci", words: []string{"NODE_ENV=production", "CI=1", "npm", "ci"}},
+		{name: "hash inside word", line: "echo a#b", words: []string{"echo", "a#b"}},
+		{name: "tilde inside word", line: "echo a~b", words: []string{"echo", "a~b"}},
+		{name: "pipe", line: "npm test | tee log", needsShell: true},
+		{name: "and operator", line: "npm ci && npm run build", needsShell: true},
+		{name: "semicolon", line: "npm ci; npm run build", needsShell: true},
+		{name: "redirect", line: "npm run build > log", needsShell: true},
+		{name: "subshell", line: "(cd app)", needsShell: true},
+		{name: "variable", line: "echo $HOME", needsShell: true},
+		{name: "variable in double quotes", line: `echo "$HOME"`, needsShell: true},
+		{name: "command substitution", line: "echo `date`", needsShell: true},
+		{name: "glob is word", line: "rm dist/*.map a?.js [ab].css", words: []string{"rm", "dist/*.map", "a?.js", "[ab].css"}},
+		{name: "leading hash is word", line: "npm ci #comment", words: []string{"npm", "ci", "#comment"}},
+		{name: "leading tilde is word", line: "ls ~/app", words: []string{"ls", "~/app"}},
+		{name: "quoted operators", line: `echo '|' "&&" \;`, words: []string{"echo", "|", "&&", ";"}},
+		{name: "unterminated single quote", line: "echo 'a", err: true},
+		{name: "unterminated double quote", line: `echo "a`, err: true},
+		{name: "escaped quote doesn't terminate", line: `echo "a\"`, err: true},
+		{name: "trailing backslash", line: `echo a\`, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			words, needsShell, err := Tokenize(test.line)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected error, got words %q", words)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if needsShell != test.needsShell {
+				t.Fatalf("needsShell is %v", needsShell)
+			}
+
+			if !test.needsShell && !reflect.DeepEqual(words, test.words) {
+				t.Fatalf("expected %q, got %q", test.words, words)
+			}
+		})
+	}
+}
+
+func TestCommandArgv(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		path string
+		args []string
+		env  []string
+		err  error
+	}{
+		{name: "string", json: `"npm run build"`, path: "npm", args: []string{"run", "build"}},
+		{name: "string with quotes", json: `"npm run build -- --name 'my app'"`, path: "npm", args: []string{"run", "build", "--", "--name", "my app"}},
+		{name: "leading assignments", json: `"NODE_ENV=production CI=1 npm ci"`, path: "npm", args: []string{"ci"}, env: []string{"NODE_ENV=production", "CI=1"}},
+		{name: "assignment after command is argument", json: `"npm config set a=b"`, path: "npm", args: []string{"config", "set", "a=b"}},
+		{name: "only assignments", json: `"CI=1"`, err: ErrEmptyCommand},
+		{name: "glob in string", json: `"rm dist/*.map"`, path: "rm", args: []string{"dist/*.map"}},
+		{name: "shell syntax in string", json: `"npm ci && npm run build"`, path: "sh", args: []string{"-c", "npm ci && npm run build"}},
+		{name: "run without shell is string", json: `{"run": "echo $HOME | tee log"}`, path: "sh", args: []string{"-c", "echo $HOME | tee log"}},
+		{name: "run with shell", json: `{"run": "npm ci && npm run build", "shell": "bash"}`, path: "bash", args: []string{"-c", "npm ci && npm run build"}},
+		{name: "structured", json: `{"cmd": "npm", "args": ["run", "build"], "cwd": "packages/app", "env": {"B": "2", "A": "1"}}`, path: "npm", args: []string{"run", "build"}, env: []string{"A=1", "B=2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var command Command
+			err := json.Unmarshal([]byte(test.json), &command)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path, args, env, err := command.Argv()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if path != test.path || !reflect.DeepEqual(args, test.args) || len(env) != len(test.env) || (len(env) > 0 && !reflect.DeepEqual(env, test.env)) {
+				t.Fatalf("unexpected argv %q %q %q", path, args, env)
+			}
+		})
+	}
+}
+
+func TestCommandValidation(t *testing.T) {
+	for _, raw := range []string{
+		`{}`,
+		`""`,
+		`{"run": "a", "cmd": "b"}`,
+		`{"run": "a", "args": ["b"]}`,
+		`{"cmd": "a", "shell": "bash"}`,
+		`{"cmd": "npm", "cwd": "../other"}`,
+		`{"cmd": "npm", "cwd": "/etc"}`,
+	} {
+		var command Command
+		if err := json.Unmarshal([]byte(raw), &command); err == nil {
+			t.Errorf("command %s was accepted", raw)
+		}
+	}
+}
+
+func TestCommandKeepsStringForm(t *testing.T) {
+	var commands []Command
+	source := `["npm ci",{"run":"npm test | tee log","shell":"sh"},{"cmd":"npm","args":["run","build"],"cwd":"app"}]`
+
+	if err := json.Unmarshal([]byte(source), &commands); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := json.Marshal(commands)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(result) != source {
+		t.Fatalf("unexpected json %s", result)
+	}
+}
@@ -0,0 +1,151 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	RuntimeHost       = "host"
+	RuntimePodman     = "podman"
+	RuntimeDocker     = "docker"
+	RuntimeBubblewrap = "bwrap"
+)
+
+var containerCounter uint64
+
+// Executor runs build commands, Cwd of args must be inside of build dir for sandboxed executors
+type Executor interface {
+	Exec(ctx context.Context, path string, args []string, eArgs ExecShellCommandArgs) (string, error)
+}
+
+// HostExecutor runs commands directly on worker host with worker user and environment
+type HostExecutor struct{}
+
+func (e *HostExecutor) Exec(ctx context.Context, path string, args []string, eArgs ExecShellCommandArgs) (string, error) {
+	return ExecShellCommand(ctx, path, args, eArgs)
+}
+
+// ContainerExecutor runs each command in new rootless container of podman or docker,
+// container root is read-only and only build dir is mounted for write
+type ContainerExecutor struct {
+	Runtime  string
+	Image    string
+	Cpus     string
+	Memory   string
+	BuildDir string
+}
+
+func (e *ContainerExecutor) Exec(ctx context.Context, path string, args []string, eArgs ExecShellCommandArgs) (string, error) {
+	if len(e.Image) == 0 {
+		return "", fmt.Errorf("image for %s sandbox is not configured", e.Runtime)
+	}
+
+	workDir := e.BuildDir
+	if len(eArgs.Cwd) > 0 {
+		workDir = eArgs.Cwd
+	}
+
+	containerName := fmt.Sprintf("mfe-worker-%d-%d", os.Getpid(), atomic.AddUint64(&containerCounter, 1))
+
+	runArgs := []string{
+		"run", "--rm", "--read-only",
+		"--name", containerName,
+		"--tmpfs", "/tmp",
+		"--env", "HOME=/tmp",
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--volume", fmt.Sprintf("%s:%s:rw", e.BuildDir, e.BuildDir),
+		"--workdir", workDir,
+	}
+
+	// files created in build dir must stay owned by worker user
+	if e.Runtime == RuntimePodman {
+		runArgs = append(runArgs, "--userns", "keep-id")
+	} else {
+		runArgs = append(runArgs, "--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()))
+	}
+
+	if len(e.Cpus) > 0 {
+		runArgs = append(runArgs, "--cpus", e.Cpus)
+	}
+
+	if len(e.Memory) > 0 {
+		runArgs = append(runArgs, "--memory", e.Memory)
+	}
+
+	// only names are passed to runtime, values are taken from its environment and don't appear in process list
+	for _, variable := range eArgs.Env {
+		name, _, _ := strings.Cut(variable, "=")
+		runArgs = append(runArgs, "--env", name)
+	}
+
+	runArgs = append(runArgs, e.Image, path)
+	runArgs = append(runArgs, args...)
+
+	// runtime client needs environment of worker user, container gets only variables listed above
+	eArgs.Cwd = ""
+	eArgs.CleanEnv = false
+	out, err := ExecShellCommand(ctx, e.Runtime, runArgs, eArgs)
+
+	// killed cli client doesn't stop container, so remove it explicitly
+	if err != nil && (ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded)) {
+		if _, rmErr := ExecShellCommand(context.Background(), e.Runtime, []string{"rm", "--force", containerName}, ExecShellCommandArgs{}); rmErr != nil {
+			log.Printf("failed on remove container %s: %s", containerName, rmErr)
+		}
+	}
+
+	return out, err
+}
+
+// BubblewrapExecutor runs commands in bubblewrap sandbox with read-only host filesystem,
+// writable build dir and without worker environment
+type BubblewrapExecutor struct {
+	BuildDir    string
+	HiddenPaths []string
+}
+
+func (e *BubblewrapExecutor) Exec(ctx context.Context, path string, args []string, eArgs ExecShellCommandArgs) (string, error) {
+	workDir := e.BuildDir
+	if len(eArgs.Cwd) > 0 {
+		workDir = eArgs.Cwd
+	}
+
+	bwrapArgs := []string{
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+		"--unshare-all", "--share-net",
+		"--die-with-parent",
+		"--new-session",
+		"--setenv", "HOME", "/tmp",
+	}
+
+	// hide worker config and storage from build scripts
+	for _, hiddenPath := range e.HiddenPaths {
+		info, err := os.Stat(hiddenPath)
+		if err != nil {
+			continue
+		}
+
+		if info.IsDir() {
+			bwrapArgs = append(bwrapArgs, "--tmpfs", hiddenPath)
+		} else {
+			bwrapArgs = append(bwrapArgs, "--ro-bind", "/dev/null", hiddenPath)
+		}
+	}
+
+	bwrapArgs = append(bwrapArgs, "--bind", e.BuildDir, e.BuildDir, "--chdir", workDir, "--", path)
+	bwrapArgs = append(bwrapArgs, args...)
+
+	// sandbox inherits clean environment of bwrap, so values of Env don't appear in its arguments
+	eArgs.Cwd = ""
+	eArgs.CleanEnv = true
+	return ExecShellCommand(ctx, RuntimeBubblewrap, bwrapArgs, eArgs)
+}
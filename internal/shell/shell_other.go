@@ -0,0 +1,12 @@
+//go:build !unix
+
+package shell
+
+import (
+	"os/exec"
+	"time"
+)
+
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.WaitDelay = 5 * time.Second
+}
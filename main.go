@@ -4,14 +4,23 @@ import (
 	"fmt"
 	"github.com/xanzy/go-gitlab"
 	"log"
+	"mfe-worker/internal/builder"
 	"mfe-worker/internal/configMap"
 	"mfe-worker/internal/dbDriver"
 	"mfe-worker/internal/di"
+	"mfe-worker/internal/events"
 	"mfe-worker/internal/fsDriver"
+	"mfe-worker/internal/gc"
 	"mfe-worker/internal/http"
+	"mfe-worker/internal/notifier"
 	"mfe-worker/internal/queue"
+	nethttp "net/http"
+	"time"
 )
 
+// gitlabRequestTimeout bounds each request to gitlab api, hung request would stall reporter and branch sync
+const gitlabRequestTimeout = 30 * time.Second
+
 func main() {
 	configMapInstance, err := configMap.NewConfigMap()
 	if err != nil {
@@ -28,13 +37,37 @@ func main() {
 		log.Fatalf("failed on init dbDriver: %s", err)
 	}
 
-	queue := queue.NewQueue(configMapInstance)
-	queue.StartQueueWorker()
+	eventBus := events.NewBus()
+
+	queue := queue.NewQueue(configMapInstance, dbDriverInstance, eventBus)
 
 	gitlabClientArgs := gitlab.WithBaseURL(fmt.Sprintf("%s/api/v4", configMapInstance.GitlabUrl))
-	gitlabClient, err := gitlab.NewClient(configMapInstance.GitlabToken, gitlabClientArgs)
+	gitlabHttpClient := gitlab.WithHTTPClient(&nethttp.Client{Timeout: gitlabRequestTimeout})
+	gitlabClient, err := gitlab.NewClient(configMapInstance.GitlabToken, gitlabClientArgs, gitlabHttpClient)
+	if err != nil {
+		log.Fatalf("failed on init gitlabClient: %s", err)
+	}
+
+	builderInstance := builder.NewBuilder(configMapInstance, queue, fsDriverInstance, dbDriverInstance, gitlabClient, eventBus)
+
+	queue.SetRunner(builderInstance.RunJob)
+
+	// notifier is started before queue, so results of restored jobs and deliveries left by previous run are not lost
+	notifierInstance := notifier.NewNotifier(configMapInstance, dbDriverInstance, eventBus, gitlabClient)
+	queue.OnFinish(notifierInstance.HandleJobResult)
+	notifierInstance.StartWorker()
+
+	if err := queue.RestoreJobs(); err != nil {
+		log.Fatalf("failed on restore queue: %s", err)
+	}
+
+	queue.StartQueueWorker()
+
+	gcInstance := gc.NewGC(configMapInstance, fsDriverInstance, dbDriverInstance, gitlabClient)
+	gcInstance.StartWorker()
+	gcInstance.StartBranchSyncWorker()
 
-	diContainer := di.NewDIContainer(configMapInstance, queue, fsDriverInstance, dbDriverInstance, gitlabClient)
+	diContainer := di.NewDIContainer(configMapInstance, queue, builderInstance, gcInstance, eventBus, fsDriverInstance, dbDriverInstance, gitlabClient)
 
 	httpServer, err := http.NewHttpServer(diContainer)
 	if err != nil {